@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/client/provisioning"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	contactPointsCmd = &cobra.Command{
+		Use:   "contact-points [flags] [name [...]]",
+		Short: "export Grafana contact points",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaContactPoint"}, len(args) == 0, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportContactPoints(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+	notificationPolicyCmd = &cobra.Command{
+		Use:   "notification-policy",
+		Short: "export Grafana notification policy tree",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaNotificationPolicy"}, true, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportNotificationPolicy(w, client, cfg, logger)
+				})
+			})
+		},
+	}
+	muteTimingsCmd = &cobra.Command{
+		Use:   "mute-timings [flags] [name [...]]",
+		Short: "export Grafana mute timings",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaMuteTiming"}, len(args) == 0, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportMuteTimings(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+	notificationTemplatesCmd = &cobra.Command{
+		Use:   "notification-templates [flags] [name [...]]",
+		Short: "export Grafana notification templates",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaNotificationTemplate"}, len(args) == 0, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportNotificationTemplates(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(contactPointsCmd, notificationPolicyCmd, muteTimingsCmd, notificationTemplatesCmd)
+}
+
+// redactedValue is the value Grafana's provisioning API returns for secure settings of a contact point.
+const redactedValue = "[REDACTED]"
+
+func exportContactPoints(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	args set.Set[string],
+	logger *slog.Logger,
+) error {
+	var skipped skippedItems
+	for contactPoint, err := range grafanaContactPoints(client, args) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return err
+			}
+			continue
+		}
+		name := contactPoint.name
+		manifest, err := operatorContactPoint(cfg, name, contactPoint.receivers)
+		if err != nil {
+			return fmt.Errorf("operator contact point: %w", err)
+		}
+		for _, receiver := range manifest.Spec.Receivers {
+			if len(receiver.ValuesFrom) > 0 {
+				logger.Warn("contact point uses secure settings and requires a secret. See https://grafana.github.io/grafana-operator/docs/alerting/contact-points/",
+					"contactpoint", name, "secret", manifest.Name)
+				break
+			}
+		}
+		if err = w.WriteManifest(manifest.Kind, "", manifest.Name, manifest); err != nil {
+			logger.Error("failed to write operator contact point", "err", err)
+			return err
+		}
+	}
+	return skipped.err()
+}
+
+// grafanaContactPoint is a Grafana contact point, with all its receivers.
+type grafanaContactPoint struct {
+	name      string
+	receivers []*models.EmbeddedContactPoint
+}
+
+// grafanaContactPoints returns all contact points whose name matches an element of args.
+// If args is empty, it returns all contact points.
+func grafanaContactPoints(c *grafanaClient, args set.Set[string]) iter.Seq2[grafanaContactPoint, error] {
+	return func(yield func(grafanaContactPoint, error) bool) {
+		ok, err := c.Provisioning.GetContactpoints(provisioning.NewGetContactpointsParams())
+		if err != nil {
+			yield(grafanaContactPoint{}, fmt.Errorf("list contact points: %w", err))
+			return
+		}
+		// Grafana returns one entry per receiver. Group them by contact point name, keeping Grafana's order.
+		var names []string
+		receivers := make(map[string][]*models.EmbeddedContactPoint)
+		for _, receiver := range ok.GetPayload() {
+			if len(args) > 0 && !args.Contains(receiver.Name) {
+				continue
+			}
+			if _, found := receivers[receiver.Name]; !found {
+				names = append(names, receiver.Name)
+			}
+			receivers[receiver.Name] = append(receivers[receiver.Name], receiver)
+		}
+		for _, name := range names {
+			if !yield(grafanaContactPoint{name: name, receivers: receivers[name]}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// contactPointManifest is a GrafanaContactPoint custom resource without its Status section.
+type contactPointManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaContactPointSpec `json:"spec"`
+}
+
+// operatorContactPoint converts the receivers of a Grafana contact point into a GrafanaContactPoint custom resource.
+//
+// Grafana doesn't return the value of secure settings. These are removed from the receiver's settings and replaced by
+// a valuesFrom entry, referring to a secret with the same name as the contact point. The secret must be created manually.
+func operatorContactPoint(cfg configuration, name string, receivers []*models.EmbeddedContactPoint) (contactPointManifest, error) {
+	manifest := contactPointManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaContactPoint",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      slug.Make(name),
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaContactPointSpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			Name:              name,
+			Receivers:         make([]v1beta1.ContactPointReceiver, 0, len(receivers)),
+		},
+	}
+	for _, receiver := range receivers {
+		settings, _ := receiver.Settings.(map[string]any)
+		if settings == nil {
+			settings = make(map[string]any)
+		}
+		var valuesFrom []v1beta1.ValueFrom
+		for _, path := range redactedSettings(settings, nil) {
+			valuesFrom = append(valuesFrom, v1beta1.ValueFrom{
+				TargetPath: strings.Join(path, "."),
+				ValueFrom: v1beta1.ValueFromSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: manifest.Name},
+						Key:                  receiver.UID + "-" + strings.Join(path, "-"),
+					},
+				},
+			})
+		}
+		encodedSettings, err := json.Marshal(settings)
+		if err != nil {
+			return contactPointManifest{}, fmt.Errorf("receiver %q: %w", receiver.UID, err)
+		}
+		manifest.Spec.Receivers = append(manifest.Spec.Receivers, v1beta1.ContactPointReceiver{
+			CustomUID:             receiver.UID,
+			Type:                  derefOrZero(receiver.Type),
+			DisableResolveMessage: receiver.DisableResolveMessage,
+			Settings:              &apiextensionsv1.JSON{Raw: encodedSettings},
+			ValuesFrom:            valuesFrom,
+		})
+	}
+	return manifest, nil
+}
+
+// redactedSettings removes all redacted values from the settings and returns their paths, in sorted order.
+func redactedSettings(settings map[string]any, prefix []string) [][]string {
+	var paths [][]string
+	for key, value := range settings {
+		path := append(slices.Clone(prefix), key)
+		switch v := value.(type) {
+		case string:
+			if v == redactedValue {
+				delete(settings, key)
+				paths = append(paths, path)
+			}
+		case map[string]any:
+			paths = append(paths, redactedSettings(v, path)...)
+		}
+	}
+	slices.SortFunc(paths, func(a, b []string) int { return slices.Compare(a, b) })
+	return paths
+}
+
+func exportNotificationPolicy(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	logger *slog.Logger,
+) error {
+	ok, err := client.Provisioning.GetPolicyTree()
+	if err != nil {
+		return fmt.Errorf("notification policy: %w", err)
+	}
+	manifest := operatorNotificationPolicy(cfg, ok.GetPayload())
+	if err = w.WriteManifest(manifest.Kind, "", manifest.Name, manifest); err != nil {
+		logger.Error("failed to write operator notification policy", "err", err)
+		return err
+	}
+	return nil
+}
+
+// notificationPolicyManifest is a GrafanaNotificationPolicy custom resource without its Status section.
+type notificationPolicyManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaNotificationPolicySpec `json:"spec"`
+}
+
+func operatorNotificationPolicy(cfg configuration, tree *models.Route) notificationPolicyManifest {
+	route := operatorRoute(tree)
+	return notificationPolicyManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaNotificationPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "notification-policy",
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaNotificationPolicySpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			Route: &v1beta1.TopLevelRoute{
+				PartialRoute:        route.PartialRoute,
+				Continue:            route.Continue,
+				MatchRe:             route.MatchRe,
+				Matchers:            route.Matchers,
+				ObjectMatchers:      route.ObjectMatchers,
+				MuteTimeIntervals:   route.MuteTimeIntervals,
+				ActiveTimeIntervals: route.ActiveTimeIntervals,
+			},
+		},
+	}
+}
+
+func operatorRoute(route *models.Route) *v1beta1.Route {
+	r := v1beta1.Route{
+		PartialRoute: v1beta1.PartialRoute{
+			GroupBy:        route.GroupBy,
+			GroupInterval:  route.GroupInterval,
+			GroupWait:      route.GroupWait,
+			Receiver:       route.Receiver,
+			RepeatInterval: route.RepeatInterval,
+		},
+		Continue:            route.Continue,
+		MatchRe:             route.MatchRe,
+		ObjectMatchers:      route.ObjectMatchers,
+		MuteTimeIntervals:   route.MuteTimeIntervals,
+		ActiveTimeIntervals: route.ActiveTimeIntervals,
+	}
+	// match is deprecated in favour of matchers: convert it, sorted by label name to keep the output stable.
+	for _, name := range slices.Sorted(maps.Keys(route.Match)) {
+		r.Matchers = append(r.Matchers, &v1beta1.Matcher{Name: name, Value: route.Match[name], IsEqual: true})
+	}
+	for _, matcher := range route.Matchers {
+		// match types, as defined by Alertmanager: 0: "=", 1: "!=", 2: "=~", 3: "!~"
+		r.Matchers = append(r.Matchers, &v1beta1.Matcher{
+			Name:    matcher.Name,
+			Value:   matcher.Value,
+			IsEqual: matcher.Type == 0 || matcher.Type == 2,
+			IsRegex: matcher.Type >= 2,
+		})
+	}
+	for _, child := range route.Routes {
+		r.Routes = append(r.Routes, operatorRoute(child))
+	}
+	return &r
+}
+
+func exportMuteTimings(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	args set.Set[string],
+	logger *slog.Logger,
+) error {
+	ok, err := client.Provisioning.GetMuteTimings()
+	if err != nil {
+		return fmt.Errorf("mute timings: %w", err)
+	}
+	for _, muteTiming := range ok.GetPayload() {
+		if len(args) > 0 && !args.Contains(muteTiming.Name) {
+			continue
+		}
+		manifest := operatorMuteTiming(cfg, muteTiming)
+		if err = w.WriteManifest(manifest.Kind, "", manifest.Name, manifest); err != nil {
+			logger.Error("failed to write operator mute timing", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// muteTimingManifest is a GrafanaMuteTiming custom resource without its Status section.
+type muteTimingManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaMuteTimingSpec `json:"spec"`
+}
+
+func operatorMuteTiming(cfg configuration, muteTiming *models.MuteTimeInterval) muteTimingManifest {
+	intervals := make([]*v1beta1.TimeInterval, 0, len(muteTiming.TimeIntervals))
+	for _, interval := range muteTiming.TimeIntervals {
+		timeInterval := v1beta1.TimeInterval{
+			DaysOfMonth: interval.DaysOfMonth,
+			Location:    interval.Location,
+			Months:      interval.Months,
+			Weekdays:    interval.Weekdays,
+			Years:       interval.Years,
+		}
+		for _, timeRange := range interval.Times {
+			timeInterval.Times = append(timeInterval.Times, &v1beta1.TimeRange{
+				StartTime: timeRange.StartTime,
+				EndTime:   timeRange.EndTime,
+			})
+		}
+		intervals = append(intervals, &timeInterval)
+	}
+	return muteTimingManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaMuteTiming",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      slug.Make(muteTiming.Name),
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaMuteTimingSpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			Name:              muteTiming.Name,
+			TimeIntervals:     intervals,
+		},
+	}
+}
+
+func exportNotificationTemplates(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	args set.Set[string],
+	logger *slog.Logger,
+) error {
+	ok, err := client.Provisioning.GetTemplates()
+	if err != nil {
+		return fmt.Errorf("notification templates: %w", err)
+	}
+	for _, template := range ok.GetPayload() {
+		if len(args) > 0 && !args.Contains(template.Name) {
+			continue
+		}
+		manifest := operatorNotificationTemplate(cfg, template)
+		if err = w.WriteManifest(manifest.Kind, "", manifest.Name, manifest); err != nil {
+			logger.Error("failed to write operator notification template", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// notificationTemplateManifest is a GrafanaNotificationTemplate custom resource without its Status section.
+type notificationTemplateManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaNotificationTemplateSpec `json:"spec"`
+}
+
+func operatorNotificationTemplate(cfg configuration, template *models.NotificationTemplate) notificationTemplateManifest {
+	return notificationTemplateManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaNotificationTemplate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      slug.Make(template.Name),
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaNotificationTemplateSpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			Name:              template.Name,
+			Template:          template.Template,
+		},
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAlerting(t *testing.T) {
+	tests := []struct {
+		name   string
+		export func(manifestWriter, *grafanaClient, configuration, *slog.Logger) error
+	}{
+		{
+			name: "contact points",
+			export: func(w manifestWriter, client *grafanaClient, cfg configuration, logger *slog.Logger) error {
+				return exportContactPoints(w, client, cfg, set.New[string](), logger)
+			},
+		},
+		{
+			name: "contact points filtered",
+			export: func(w manifestWriter, client *grafanaClient, cfg configuration, logger *slog.Logger) error {
+				return exportContactPoints(w, client, cfg, set.New("email"), logger)
+			},
+		},
+		{
+			name:   "notification policy",
+			export: exportNotificationPolicy,
+		},
+		{
+			name: "mute timings",
+			export: func(w manifestWriter, client *grafanaClient, cfg configuration, logger *slog.Logger) error {
+				return exportMuteTimings(w, client, cfg, set.New[string](), logger)
+			},
+		},
+		{
+			name: "notification templates",
+			export: func(w manifestWriter, client *grafanaClient, cfg configuration, logger *slog.Logger) error {
+				return exportNotificationTemplates(w, client, cfg, set.New[string](), logger)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			v := viper.New()
+			v.Set("grafana.url", "http://grafana")
+			v.Set("namespace", "monitoring")
+			cfg := configurationFromViper(v)
+			client := grafanaClient{
+				Provisioning: fakeProvisioningClient{
+					contactPoints: models.ContactPoints{
+						{Name: "email", UID: "email-1", Type: constP("email"), Settings: map[string]any{"addresses": "ops@example.com"}},
+						{Name: "slack", UID: "slack-1", Type: constP("slack"), Settings: map[string]any{"recipient": "#alerts", "token": "[REDACTED]"}},
+						{Name: "slack", UID: "slack-2", Type: constP("webhook"), Settings: map[string]any{
+							"url":           "http://webhook",
+							"authorization": map[string]any{"credentials": "[REDACTED]"},
+						}},
+					},
+					policyTree: &models.Route{
+						Receiver: "email",
+						GroupBy:  []string{"grafana_folder", "alertname"},
+						Routes: []*models.Route{
+							{
+								Receiver:          "slack",
+								Matchers:          models.Matchers{{Name: "severity", Value: "critical|warning", Type: 2}},
+								MuteTimeIntervals: []string{"weekends"},
+							},
+							{
+								Receiver: "email",
+								Match:    map[string]string{"team": "ops"},
+								Continue: true,
+							},
+						},
+					},
+					muteTimings: models.MuteTimings{
+						{Name: "weekends", TimeIntervals: []*models.TimeIntervalItem{{
+							Weekdays: []string{"saturday", "sunday"},
+							Times:    []*models.TimeIntervalTimeRange{{StartTime: "00:00", EndTime: "24:00"}},
+						}}},
+					},
+					templates: models.NotificationTemplates{
+						{Name: "slack.title", Template: `{{ define "slack.title" }}{{ .CommonLabels.alertname }}{{ end }}`},
+					},
+				},
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, tt.export(&streamWriter{w: &buf}, &client, cfg, logger))
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
+
+func TestExportContactPoints_Errors(t *testing.T) {
+	client := grafanaClient{Provisioning: fakeProvisioningClient{err: errors.New("server error")}}
+
+	var buf bytes.Buffer
+	err := exportContactPoints(&streamWriter{w: &buf}, &client, configuration{}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "list contact points: server error", err.Error())
+
+	err = exportContactPoints(&streamWriter{w: &buf}, &client, configuration{ContinueOnError: true}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "1 item(s) skipped:\nlist contact points: server error", err.Error())
+	assert.Empty(t, buf.String())
+}
+
+func Test_redactedSettings(t *testing.T) {
+	settings := map[string]any{
+		"url":           "http://webhook",
+		"password":      "[REDACTED]",
+		"authorization": map[string]any{"scheme": "Bearer", "credentials": "[REDACTED]"},
+	}
+	paths := redactedSettings(settings, nil)
+	assert.Equal(t, [][]string{{"authorization", "credentials"}, {"password"}}, paths)
+	assert.Equal(t, map[string]any{
+		"url":           "http://webhook",
+		"authorization": map[string]any{"scheme": "Bearer"},
+	}, settings)
+}
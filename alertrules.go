@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"time"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	alertRulesCmd = &cobra.Command{
+		Use:   "alert-rules [flags] [group [...]]",
+		Short: "export Grafana alert rule groups",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaAlertRuleGroup"}, len(args) == 0, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportAlertRuleGroups(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(alertRulesCmd)
+}
+
+func exportAlertRuleGroups(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	args set.Set[string],
+	logger *slog.Logger,
+) error {
+	var skipped skippedItems
+	folderTitles := make(map[string]string)
+	for group, err := range grafanaAlertRuleGroups(client, args) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return err
+			}
+			continue
+		}
+		folderTitle, ok := folderTitles[group.FolderUID]
+		if !ok {
+			folder, err := client.Folders.GetFolderByUID(group.FolderUID)
+			if err != nil {
+				if err = skipped.skip(cfg, logger, fmt.Errorf("folder %q: %w", group.FolderUID, err)); err != nil {
+					return err
+				}
+				continue
+			}
+			folderTitle = folder.GetPayload().Title
+			folderTitles[group.FolderUID] = folderTitle
+		}
+		manifest, err := operatorAlertRuleGroup(cfg, group)
+		if err != nil {
+			return fmt.Errorf("operator alert rule group: %w", err)
+		}
+		if err = w.WriteManifest(manifest.Kind, folderTitle, manifest.Name, manifest); err != nil {
+			logger.Error("failed to write operator alert rule group", "err", err)
+			return err
+		}
+	}
+	return skipped.err()
+}
+
+// grafanaAlertRuleGroups returns all alert rule groups whose title matches an element of args.
+// If args is empty, it returns all alert rule groups.
+//
+// The provisioning API has no call to list the rule groups, so we derive them from the list of alert rules
+// and then fetch each group in each folder. Groups are returned sorted by folder UID and title.
+func grafanaAlertRuleGroups(c *grafanaClient, args set.Set[string]) iter.Seq2[*models.AlertRuleGroup, error] {
+	return func(yield func(*models.AlertRuleGroup, error) bool) {
+		rules, err := c.Provisioning.GetAlertRules()
+		if err != nil {
+			yield(nil, fmt.Errorf("list alert rules: %w", err))
+			return
+		}
+		type groupKey struct{ folderUID, title string }
+		var keys []groupKey
+		for _, rule := range rules.GetPayload() {
+			if rule.FolderUID == nil || rule.RuleGroup == nil {
+				continue
+			}
+			if len(args) > 0 && !args.Contains(*rule.RuleGroup) {
+				continue
+			}
+			keys = append(keys, groupKey{folderUID: *rule.FolderUID, title: *rule.RuleGroup})
+		}
+		slices.SortFunc(keys, func(a, b groupKey) int {
+			return cmp.Or(cmp.Compare(a.folderUID, b.folderUID), cmp.Compare(a.title, b.title))
+		})
+		for _, key := range slices.Compact(keys) {
+			group, err := c.Provisioning.GetAlertRuleGroup(key.title, key.folderUID)
+			if err != nil {
+				if !yield(nil, fmt.Errorf("alert rule group %q in folder %q: %w", key.title, key.folderUID, err)) {
+					return
+				}
+				continue
+			}
+			if !yield(group.GetPayload(), nil) {
+				return
+			}
+		}
+	}
+}
+
+// alertRuleGroupManifest is a GrafanaAlertRuleGroup custom resource without its Status section.
+type alertRuleGroupManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaAlertRuleGroupSpec `json:"spec"`
+}
+
+// operatorAlertRuleGroup converts a Grafana alert rule group to a GrafanaAlertRuleGroup custom resource.
+// The group refers to its folder by GrafanaFolder resource if the folder mode is "ref". Otherwise, it uses the folder's UID.
+func operatorAlertRuleGroup(cfg configuration, group *models.AlertRuleGroup) (alertRuleGroupManifest, error) {
+	manifest := alertRuleGroupManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaAlertRuleGroup",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			// group titles are only unique within a folder
+			Name:      folderResourceName(group.FolderUID) + "-" + slug.Make(group.Title),
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaAlertRuleGroupSpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			Name:              group.Title,
+			Interval:          metav1.Duration{Duration: time.Duration(group.Interval) * time.Second},
+			Rules:             make([]v1beta1.AlertRule, 0, len(group.Rules)),
+		},
+	}
+	if cfg.FolderMode == folderModeRef {
+		manifest.Spec.FolderRef = folderResourceName(group.FolderUID)
+	} else {
+		manifest.Spec.FolderUID = group.FolderUID
+	}
+	for _, rule := range group.Rules {
+		r, err := operatorAlertRule(rule)
+		if err != nil {
+			return alertRuleGroupManifest{}, fmt.Errorf("rule %q: %w", rule.UID, err)
+		}
+		manifest.Spec.Rules = append(manifest.Spec.Rules, r)
+	}
+	return manifest, nil
+}
+
+func operatorAlertRule(rule *models.ProvisionedAlertRule) (v1beta1.AlertRule, error) {
+	r := v1beta1.AlertRule{
+		Annotations:  rule.Annotations,
+		Condition:    derefOrZero(rule.Condition),
+		Data:         make([]*v1beta1.AlertQuery, 0, len(rule.Data)),
+		ExecErrState: derefOrZero(rule.ExecErrState),
+		IsPaused:     rule.IsPaused,
+		Labels:       rule.Labels,
+		NoDataState:  rule.NoDataState,
+		Title:        derefOrZero(rule.Title),
+		UID:          rule.UID,
+	}
+	if rule.For != nil {
+		r.For = constP(rule.For.String())
+	}
+	if rule.KeepFiringFor != 0 {
+		r.KeepFiringFor = &metav1.Duration{Duration: time.Duration(rule.KeepFiringFor)}
+	}
+	if rule.MissingSeriesEvalsToResolve != 0 {
+		r.MissingSeriesEvalsToResolve = constP(rule.MissingSeriesEvalsToResolve)
+	}
+	if s := rule.NotificationSettings; s != nil {
+		r.NotificationSettings = &v1beta1.NotificationSettings{
+			Receiver:            derefOrZero(s.Receiver),
+			GroupBy:             s.GroupBy,
+			GroupWait:           s.GroupWait,
+			GroupInterval:       s.GroupInterval,
+			RepeatInterval:      s.RepeatInterval,
+			MuteTimeIntervals:   s.MuteTimeIntervals,
+			ActiveTimeIntervals: s.ActiveTimeIntervals,
+		}
+	}
+	if rule.Record != nil {
+		r.Record = &v1beta1.Record{
+			From:                derefOrZero(rule.Record.From),
+			Metric:              derefOrZero(rule.Record.Metric),
+			TargetDatasourceUID: rule.Record.TargetDatasourceUID,
+		}
+	}
+	for _, query := range rule.Data {
+		model, err := json.Marshal(query.Model)
+		if err != nil {
+			return v1beta1.AlertRule{}, fmt.Errorf("query %q: %w", query.RefID, err)
+		}
+		r.Data = append(r.Data, &v1beta1.AlertQuery{
+			DatasourceUID:     query.DatasourceUID,
+			Model:             &apiextensionsv1.JSON{Raw: model},
+			QueryType:         query.QueryType,
+			RefID:             query.RefID,
+			RelativeTimeRange: query.RelativeTimeRange,
+		})
+	}
+	return r, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/go-openapi/strfmt"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/client/provisioning"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAlertRuleGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		folderMode string
+		args       []string
+		rules      models.ProvisionedAlertRules
+	}{
+		{name: "unfiltered"},
+		{name: "filtered", args: []string{"group 2"}},
+		{name: "folder reference", folderMode: "ref"},
+		{
+			name: "duplicate folder titles",
+			rules: models.ProvisionedAlertRules{
+				testAlertRule("rule 1", "f1-alerts", "cpu"),
+				testAlertRule("rule 2", "f2-alerts", "cpu"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			v := viper.New()
+			v.Set("grafana.url", "http://grafana")
+			v.Set("namespace", "monitoring")
+			v.Set("folder-mode", tt.folderMode)
+			cfg := configurationFromViper(v)
+			client := grafanaClient{
+				Folders: fakeFolderFetcher{folders: map[string][]*models.FolderSearchHit{
+					"": {
+						{Title: "folder 1", UID: "f1"},
+						{Title: "folder 2", UID: "f2"},
+					},
+					"f1": {{Title: "alerts", UID: "f1-alerts", ParentUID: "f1"}},
+					"f2": {{Title: "alerts", UID: "f2-alerts", ParentUID: "f2"}},
+				}},
+				Provisioning: fakeProvisioningClient{alertRules: tt.rules},
+			}
+			if tt.rules == nil {
+				client.Provisioning = fakeProvisioningClient{alertRules: models.ProvisionedAlertRules{
+					testAlertRule("rule 1", "f1", "group 1"),
+					testAlertRule("rule 2", "f1", "group 1"),
+					testAlertRule("rule 3", "f2", "group 2"),
+				}}
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, exportAlertRuleGroups(&streamWriter{w: &buf}, &client, cfg, set.New(tt.args...), logger))
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
+
+func TestExportAlertRuleGroups_Errors(t *testing.T) {
+	client := grafanaClient{
+		Folders: fakeFolderFetcher{folders: map[string][]*models.FolderSearchHit{"": {{Title: "folder 1", UID: "f1"}}}},
+		Provisioning: fakeProvisioningClient{alertRules: models.ProvisionedAlertRules{
+			testAlertRule("rule 1", "f1", "group 1"),
+			testAlertRule("rule 2", "missing", "group 2"),
+		}},
+	}
+
+	var buf bytes.Buffer
+	err := exportAlertRuleGroups(&streamWriter{w: &buf}, &client, configuration{}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, `folder "missing": folder not found`, err.Error())
+
+	buf.Reset()
+	err = exportAlertRuleGroups(&streamWriter{w: &buf}, &client, configuration{ContinueOnError: true}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "1 item(s) skipped:\n"+`folder "missing": folder not found`, err.Error())
+	assert.Contains(t, buf.String(), "name: group 1")
+
+	client.Provisioning = fakeProvisioningClient{err: errors.New("server error")}
+	err = exportAlertRuleGroups(&streamWriter{w: &bytes.Buffer{}}, &client, configuration{}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "list alert rules: server error", err.Error())
+}
+
+func testAlertRule(title, folderUID, group string) *models.ProvisionedAlertRule {
+	return &models.ProvisionedAlertRule{
+		UID:          slug.Make(title),
+		Title:        constP(title),
+		FolderUID:    constP(folderUID),
+		RuleGroup:    constP(group),
+		Condition:    constP("B"),
+		ExecErrState: constP("Error"),
+		NoDataState:  constP("NoData"),
+		For:          constP(strfmt.Duration(5 * time.Minute)),
+		Labels:       map[string]string{"severity": "critical"},
+		Annotations:  map[string]string{"summary": title + " is firing"},
+		Data: []*models.AlertQuery{
+			{
+				RefID:             "A",
+				DatasourceUID:     "prometheus",
+				RelativeTimeRange: &models.RelativeTimeRange{From: 600},
+				Model:             map[string]any{"expr": "up == 0", "refId": "A"},
+			},
+			{
+				RefID:         "B",
+				DatasourceUID: "__expr__",
+				Model:         map[string]any{"expression": "A", "type": "threshold", "refId": "B"},
+			},
+		},
+	}
+}
+
+var _ grafanaProvisioningClient = fakeProvisioningClient{}
+
+type fakeProvisioningClient struct {
+	alertRules    models.ProvisionedAlertRules
+	contactPoints models.ContactPoints
+	policyTree    *models.Route
+	muteTimings   models.MuteTimings
+	templates     models.NotificationTemplates
+	err           error
+}
+
+func (f fakeProvisioningClient) GetAlertRules(_ ...provisioning.ClientOption) (*provisioning.GetAlertRulesOK, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := provisioning.NewGetAlertRulesOK()
+	result.Payload = f.alertRules
+	return result, nil
+}
+
+func (f fakeProvisioningClient) GetAlertRuleGroup(group string, folderUID string, _ ...provisioning.ClientOption) (*provisioning.GetAlertRuleGroupOK, error) {
+	result := provisioning.NewGetAlertRuleGroupOK()
+	result.Payload = &models.AlertRuleGroup{Title: group, FolderUID: folderUID, Interval: 60}
+	for _, rule := range f.alertRules {
+		if *rule.FolderUID == folderUID && *rule.RuleGroup == group {
+			result.Payload.Rules = append(result.Payload.Rules, rule)
+		}
+	}
+	if len(result.Payload.Rules) == 0 {
+		return nil, errors.New("alert rule group not found")
+	}
+	return result, nil
+}
+
+func (f fakeProvisioningClient) GetContactpoints(_ *provisioning.GetContactpointsParams, _ ...provisioning.ClientOption) (*provisioning.GetContactpointsOK, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := provisioning.NewGetContactpointsOK()
+	result.Payload = f.contactPoints
+	return result, nil
+}
+
+func (f fakeProvisioningClient) GetPolicyTree(_ ...provisioning.ClientOption) (*provisioning.GetPolicyTreeOK, error) {
+	if f.policyTree == nil {
+		return nil, errors.New("policy tree not found")
+	}
+	result := provisioning.NewGetPolicyTreeOK()
+	result.Payload = f.policyTree
+	return result, nil
+}
+
+func (f fakeProvisioningClient) GetMuteTimings(_ ...provisioning.ClientOption) (*provisioning.GetMuteTimingsOK, error) {
+	result := provisioning.NewGetMuteTimingsOK()
+	result.Payload = f.muteTimings
+	return result, nil
+}
+
+func (f fakeProvisioningClient) GetTemplates(_ ...provisioning.ClientOption) (*provisioning.GetTemplatesOK, error) {
+	result := provisioning.NewGetTemplatesOK()
+	result.Payload = f.templates
+	return result, nil
+}
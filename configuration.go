@@ -1,30 +1,85 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/go-openapi/strfmt"
 	goapi "github.com/grafana/grafana-openapi-client-go/client"
 	"github.com/grafana/grafana-openapi-client-go/client/dashboards"
 	"github.com/grafana/grafana-openapi-client-go/client/datasources"
+	"github.com/grafana/grafana-openapi-client-go/client/folders"
+	"github.com/grafana/grafana-openapi-client-go/client/library_elements"
+	"github.com/grafana/grafana-openapi-client-go/client/orgs"
+	"github.com/grafana/grafana-openapi-client-go/client/provisioning"
 	"github.com/grafana/grafana-openapi-client-go/client/search"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
 	"github.com/spf13/viper"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type configuration struct {
-	Grafana   grafanaConfiguration
-	Namespace string
-	Tags      []string
-	Folders   bool
+	Grafana             grafanaConfiguration
+	Retry               retryConfiguration
+	Org                 int64
+	AllOrgs             bool
+	Orgs                map[string]orgConfiguration
+	Namespace           string
+	ContinueOnError     bool
+	Tags                []string
+	Folders             bool
+	FolderMode          string
+	LibraryPanels       bool
+	WithDatasources     bool
+	Concurrency         int
+	ConfigMaps          bool
+	TemplateDatasources bool
+	Gzip                gzipConfiguration
+	Normalize           normalizeConfiguration
+	DashboardFilter     dashboardFilter
+	DatasourceFilter    datasourceFilter
+	Output              outputConfiguration
+	Diff                diffConfiguration
+	Push                pushConfiguration
+}
+
+type outputConfiguration struct {
+	Dir      string
+	Layout   string
+	Existing string
+	Prune    bool
+}
+
+type gzipConfiguration struct {
+	Enabled   bool
+	Threshold int
 }
 
 type grafanaConfiguration struct {
-	URL      string
-	Token    string
-	Operator grafanaOperatorConfiguration
+	URL       string
+	BasePath  string
+	Token     string
+	TokenFile string
+	Username  string
+	Password  string
+	TLS       tlsConfiguration
+	Operator  grafanaOperatorConfiguration
+}
+
+type tlsConfiguration struct {
+	CA                 string
+	Cert               string
+	Key                string
+	ServerName         string
+	InsecureSkipVerify bool
 }
 
 type grafanaOperatorConfiguration struct {
@@ -48,18 +103,105 @@ func configurationFromViper(v *viper.Viper) configuration {
 	}
 	return configuration{
 		Grafana: grafanaConfiguration{
-			URL:   v.GetString("grafana.url"),
-			Token: v.GetString("grafana.token"),
+			URL:       v.GetString("grafana.url"),
+			BasePath:  v.GetString("grafana.basePath"),
+			Token:     v.GetString("grafana.token"),
+			TokenFile: v.GetString("grafana.tokenFile"),
+			Username:  v.GetString("grafana.username"),
+			Password:  v.GetString("grafana.password"),
+			TLS: tlsConfiguration{
+				CA:                 v.GetString("grafana.tls.ca"),
+				Cert:               v.GetString("grafana.tls.cert"),
+				Key:                v.GetString("grafana.tls.key"),
+				ServerName:         v.GetString("grafana.tls.serverName"),
+				InsecureSkipVerify: v.GetBool("grafana.tls.insecureSkipVerify"),
+			},
 			Operator: grafanaOperatorConfiguration{
 				Labels: labels,
 			},
 		},
-		Namespace: v.GetString("namespace"),
-		Tags:      tags,
-		Folders:   v.GetBool("folders"),
+		Retry: retryConfiguration{
+			MaxRetries: v.GetInt("retry.max"),
+			Backoff:    v.GetDuration("retry.backoff"),
+			RateLimit:  v.GetFloat64("rateLimit"),
+		},
+		Org:                 v.GetInt64("org"),
+		AllOrgs:             v.GetBool("all-orgs"),
+		Orgs:                orgsFromViper(v),
+		Namespace:           v.GetString("namespace"),
+		ContinueOnError:     v.GetBool("continue-on-error"),
+		Tags:                tags,
+		Folders:             v.GetBool("folders"),
+		FolderMode:          v.GetString("folder-mode"),
+		LibraryPanels:       v.GetBool("library-panels"),
+		WithDatasources:     v.GetBool("with-datasources"),
+		Concurrency:         v.GetInt("concurrency"),
+		ConfigMaps:          v.GetBool("config-maps"),
+		TemplateDatasources: v.GetBool("template-datasources"),
+		Gzip: gzipConfiguration{
+			Enabled:   v.GetBool("gzip.enabled"),
+			Threshold: v.GetInt("gzip.threshold"),
+		},
+		Normalize: normalizeConfiguration{
+			Enabled: v.GetBool("normalize.enabled"),
+			Fields:  v.GetStringSlice("normalize.fields"),
+		},
+		DashboardFilter: dashboardFilter{
+			Tags:               v.GetStringSlice("dashboards.include.tag"),
+			UIDs:               v.GetStringSlice("dashboards.include.uid"),
+			TitleRegex:         v.GetString("dashboards.include.titleRegex"),
+			FolderGlobs:        v.GetStringSlice("dashboards.include.folderGlob"),
+			ExcludeTags:        v.GetStringSlice("dashboards.exclude.tag"),
+			ExcludeUIDs:        v.GetStringSlice("dashboards.exclude.uid"),
+			ExcludeTitleRegex:  v.GetString("dashboards.exclude.titleRegex"),
+			ExcludeFolderGlobs: v.GetStringSlice("dashboards.exclude.folderGlob"),
+		},
+		DatasourceFilter: datasourceFilter{
+			Types:        v.GetStringSlice("datasources.include.type"),
+			UIDs:         v.GetStringSlice("datasources.include.uid"),
+			ExcludeNames: v.GetStringSlice("datasources.exclude.name"),
+			ExcludeTypes: v.GetStringSlice("datasources.exclude.type"),
+			ExcludeUIDs:  v.GetStringSlice("datasources.exclude.uid"),
+		},
+		Output: outputConfiguration{
+			Dir:      v.GetString("output.dir"),
+			Layout:   v.GetString("output.layout"),
+			Existing: v.GetString("output.existing"),
+			Prune:    v.GetBool("output.prune"),
+		},
+		Diff: diffConfiguration{
+			Against: v.GetString("diff.against"),
+			Format:  v.GetString("diff.format"),
+		},
+		Push: pushConfiguration{
+			DryRun:   v.GetBool("push.dryRun"),
+			Existing: v.GetString("push.existing"),
+		},
 	}
 }
 
+// applyProfile applies the configuration profile selected by the "profile" key. The settings of a profile
+// (profiles.<name>.*) override those at the top level of the configuration file, e.g.
+//
+//	namespace: monitoring
+//	profiles:
+//	  production:
+//	    grafana:
+//	      url: https://grafana.example.com
+//
+// Command-line flags and environment variables still take precedence over a profile.
+func applyProfile(v *viper.Viper) error {
+	name := v.GetString("profile")
+	if name == "" {
+		return nil
+	}
+	profile := v.Sub("profiles." + name)
+	if profile == nil {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	return v.MergeConfigMap(profile.AllSettings())
+}
+
 func (c configuration) grafanaClient() (*grafanaClient, error) {
 	target, err := url.Parse(c.Grafana.URL)
 	if err != nil {
@@ -68,20 +210,96 @@ func (c configuration) grafanaClient() (*grafanaClient, error) {
 	if target.Scheme == "" {
 		return nil, fmt.Errorf("invalid grafana.url %q: invalid scheme %q", c.Grafana.URL, target.Scheme)
 	}
+	// Grafana may be served under a sub-path (e.g. behind a reverse proxy). grafana.basePath overrides the URL's path.
+	basePath := target.Path
+	if c.Grafana.BasePath != "" {
+		basePath = c.Grafana.BasePath
+	}
+	tlsConfig, err := c.Grafana.TLS.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
 	cfg := goapi.TransportConfig{
-		Host:     target.Host,
-		BasePath: "/api",
-		Schemes:  []string{target.Scheme},
-		APIKey:   c.Grafana.Token,
+		Host:      target.Host,
+		BasePath:  path.Join("/", basePath, "api"),
+		Schemes:   []string{target.Scheme},
+		OrgID:     c.Org,
+		TLSConfig: tlsConfig,
+	}
+	if err = c.Grafana.setCredentials(&cfg); err != nil {
+		return nil, err
 	}
 	client := goapi.NewHTTPClientWithConfig(strfmt.Default, &cfg)
+	r := newRetrier(c.Retry)
 	return &grafanaClient{
-		Search:      client.Search,
-		Dashboards:  client.Dashboards,
-		Datasources: client.Datasources,
+		Search:          retryingSearchClient{grafanaSearchClient: client.Search, retrier: r},
+		Dashboards:      retryingDashboardClient{grafanaDashboardClient: client.Dashboards, retrier: r},
+		Datasources:     retryingDatasourcesClient{grafanaDatasourcesClient: client.Datasources, retrier: r},
+		Folders:         client.Folders,
+		Provisioning:    client.Provisioning,
+		LibraryElements: client.LibraryElements,
+		Orgs:            client.Orgs,
+		// writes aren't retried: a request that timed out may still have been applied.
+		DashboardWriter:  client.Dashboards,
+		DatasourceWriter: client.Datasources,
+		FolderWriter:     client.Folders,
 	}, nil
 }
 
+// setCredentials sets the credentials to authenticate to Grafana. A token (either set directly or read from a file)
+// takes precedence over basic auth.
+func (c grafanaConfiguration) setCredentials(cfg *goapi.TransportConfig) error {
+	token := c.Token
+	if token == "" && c.TokenFile != "" {
+		// read the file on startup, so a mounted Kubernetes secret doesn't need to be exposed in the environment.
+		content, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return fmt.Errorf("grafana.tokenFile: %w", err)
+		}
+		if token = strings.TrimSpace(string(content)); token == "" {
+			return fmt.Errorf("grafana.tokenFile: %s is empty", c.TokenFile)
+		}
+	}
+	switch {
+	case token != "":
+		cfg.APIKey = token
+	case c.Username != "":
+		cfg.BasicAuth = url.UserPassword(c.Username, c.Password)
+	default:
+		return errors.New("no grafana credentials configured: set grafana.token, grafana.tokenFile or grafana.username & grafana.password")
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS configuration to connect to Grafana, or nil if no TLS options are set.
+func (c tlsConfiguration) tlsConfig() (*tls.Config, error) {
+	if c == (tlsConfiguration{}) {
+		return nil, nil
+	}
+	cfg := tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CA != "" {
+		ca, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("ca: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("ca: no certificates found in %s", c.CA)
+		}
+	}
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return &cfg, nil
+}
+
 func (c configuration) instanceSelector() *metav1.LabelSelector {
 	if c.Grafana.Operator.Labels == nil {
 		return nil
@@ -89,10 +307,26 @@ func (c configuration) instanceSelector() *metav1.LabelSelector {
 	return &metav1.LabelSelector{MatchLabels: c.Grafana.Operator.Labels}
 }
 
+// commonSpec returns the spec fields shared by all custom resources that grope generates.
+func (c configuration) commonSpec() v1beta1.GrafanaCommonSpec {
+	return v1beta1.GrafanaCommonSpec{
+		ResyncPeriod:              metav1.Duration{Duration: 10 * time.Minute},
+		AllowCrossNamespaceImport: true,
+		InstanceSelector:          c.instanceSelector(),
+	}
+}
+
 type grafanaClient struct {
-	Search      grafanaSearchClient
-	Dashboards  grafanaDashboardClient
-	Datasources grafanaDatasourcesClient
+	Search           grafanaSearchClient
+	Dashboards       grafanaDashboardClient
+	Datasources      grafanaDatasourcesClient
+	Folders          grafanaFoldersClient
+	Provisioning     grafanaProvisioningClient
+	LibraryElements  grafanaLibraryElementsClient
+	Orgs             grafanaOrgsClient
+	DashboardWriter  grafanaDashboardWriter
+	DatasourceWriter grafanaDatasourceWriter
+	FolderWriter     grafanaFolderWriter
 }
 
 type grafanaSearchClient interface {
@@ -105,8 +339,54 @@ type grafanaDashboardClient interface {
 
 type grafanaDatasourcesClient interface {
 	GetDataSourceByName(name string, opts ...datasources.ClientOption) (*datasources.GetDataSourceByNameOK, error)
+	GetDataSourceByUID(uid string, opts ...datasources.ClientOption) (*datasources.GetDataSourceByUIDOK, error)
+	GetDataSources(opts ...datasources.ClientOption) (*datasources.GetDataSourcesOK, error)
+}
+
+type grafanaFoldersClient interface {
+	GetFolders(*folders.GetFoldersParams, ...folders.ClientOption) (*folders.GetFoldersOK, error)
+	GetFolderByUID(string, ...folders.ClientOption) (*folders.GetFolderByUIDOK, error)
+}
+
+type grafanaProvisioningClient interface {
+	GetAlertRules(...provisioning.ClientOption) (*provisioning.GetAlertRulesOK, error)
+	GetAlertRuleGroup(string, string, ...provisioning.ClientOption) (*provisioning.GetAlertRuleGroupOK, error)
+	GetContactpoints(*provisioning.GetContactpointsParams, ...provisioning.ClientOption) (*provisioning.GetContactpointsOK, error)
+	GetPolicyTree(...provisioning.ClientOption) (*provisioning.GetPolicyTreeOK, error)
+	GetMuteTimings(...provisioning.ClientOption) (*provisioning.GetMuteTimingsOK, error)
+	GetTemplates(...provisioning.ClientOption) (*provisioning.GetTemplatesOK, error)
+}
+
+type grafanaLibraryElementsClient interface {
+	GetLibraryElements(*library_elements.GetLibraryElementsParams, ...library_elements.ClientOption) (*library_elements.GetLibraryElementsOK, error)
+	GetLibraryElementByUID(string, ...library_elements.ClientOption) (*library_elements.GetLibraryElementByUIDOK, error)
+}
+
+type grafanaOrgsClient interface {
+	SearchOrgs(*orgs.SearchOrgsParams, ...orgs.ClientOption) (*orgs.SearchOrgsOK, error)
+}
+
+type grafanaDashboardWriter interface {
+	PostDashboard(*models.SaveDashboardCommand, ...dashboards.ClientOption) (*dashboards.PostDashboardOK, error)
+}
+
+type grafanaDatasourceWriter interface {
+	AddDataSource(*models.AddDataSourceCommand, ...datasources.ClientOption) (*datasources.AddDataSourceOK, error)
+	UpdateDataSourceByUID(string, *models.UpdateDataSourceCommand, ...datasources.ClientOption) (*datasources.UpdateDataSourceByUIDOK, error)
+}
+
+type grafanaFolderWriter interface {
+	CreateFolder(*models.CreateFolderCommand, ...folders.ClientOption) (*folders.CreateFolderOK, error)
 }
 
 func constP[T any](v T) *T {
 	return &v
 }
+
+func derefOrZero[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-openapi-client-go/client/search"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfiguration_grafanaClient_BasePath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/grafana/api/search", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"title":"db 1","type":"dash-db","uid":"1"}]`))
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	tests := []struct {
+		name     string
+		url      string
+		basePath string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		{name: "path in url", url: s.URL + "/grafana", wantErr: assert.NoError},
+		{name: "path in url with trailing slash", url: s.URL + "/grafana/", wantErr: assert.NoError},
+		{name: "base path", url: s.URL, basePath: "grafana", wantErr: assert.NoError},
+		{name: "base path overrides url", url: s.URL + "/foo", basePath: "/grafana/", wantErr: assert.NoError},
+		{name: "no path", url: s.URL, wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("grafana.url", tt.url)
+			v.Set("grafana.basePath", tt.basePath)
+			v.Set("grafana.token", "token")
+			c, err := configurationFromViper(v).grafanaClient()
+			require.NoError(t, err)
+
+			hits, err := c.Search.Search(search.NewSearchParams())
+			tt.wantErr(t, err)
+			if err == nil {
+				require.Len(t, hits.GetPayload(), 1)
+				assert.Equal(t, "db 1", hits.GetPayload()[0].Title)
+			}
+		})
+	}
+}
+
+func TestConfiguration_grafanaClient_InvalidURL(t *testing.T) {
+	for _, u := range []string{"/grafana", "http://[::1"} {
+		v := viper.New()
+		v.Set("grafana.url", u)
+		_, err := configurationFromViper(v).grafanaClient()
+		assert.Error(t, err, u)
+	}
+}
+
+func TestConfiguration_grafanaClient_TLS(t *testing.T) {
+	tmpDir := t.TempDir()
+	clientCert, clientKey, clientPool := testClientCertificate(t, tmpDir)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	})
+	s := httptest.NewTLSServer(handler)
+	t.Cleanup(s.Close)
+	mtls := httptest.NewUnstartedServer(handler)
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	mtls.StartTLS()
+	t.Cleanup(mtls.Close)
+
+	ca := filepath.Join(tmpDir, "ca.pem")
+	require.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600))
+
+	tests := []struct {
+		name        string
+		url         string
+		tls         map[string]any
+		wantErr     assert.ErrorAssertionFunc
+		wantCallErr assert.ErrorAssertionFunc
+	}{
+		{name: "unknown authority", url: s.URL, wantErr: assert.NoError, wantCallErr: assert.Error},
+		{name: "ca", url: s.URL, tls: map[string]any{"ca": ca}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "insecure", url: s.URL, tls: map[string]any{"insecureSkipVerify": true}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "valid server name", url: s.URL, tls: map[string]any{"ca": ca, "serverName": "example.com"}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "invalid server name", url: s.URL, tls: map[string]any{"ca": ca, "serverName": "grafana.example.org"}, wantErr: assert.NoError, wantCallErr: assert.Error},
+		{name: "missing ca", url: s.URL, tls: map[string]any{"ca": filepath.Join(tmpDir, "missing.pem")}, wantErr: assert.Error},
+		{name: "invalid ca", url: s.URL, tls: map[string]any{"ca": clientKey}, wantErr: assert.Error},
+		{name: "mtls", url: mtls.URL, tls: map[string]any{"ca": ca, "cert": clientCert, "key": clientKey}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "mtls without client certificate", url: mtls.URL, tls: map[string]any{"ca": ca}, wantErr: assert.NoError, wantCallErr: assert.Error},
+		{name: "missing key", url: mtls.URL, tls: map[string]any{"ca": ca, "cert": clientCert}, wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("grafana.url", tt.url)
+			v.Set("grafana.token", "token")
+			for key, value := range tt.tls {
+				v.Set("grafana.tls."+key, value)
+			}
+			c, err := configurationFromViper(v).grafanaClient()
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			_, err = c.Search.Search(search.NewSearchParams())
+			tt.wantCallErr(t, err)
+		})
+	}
+}
+
+// testClientCertificate creates a self-signed client certificate. It returns the certificate & key file names and
+// a pool that can be used to verify the certificate.
+func testClientCertificate(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "grope"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile, pool
+}
+
+func TestConfiguration_grafanaClient_Credentials(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization") == "Bearer token"
+		user, password, basicAuth := r.BasicAuth()
+		if !token && (!basicAuth || user != "admin" || password != "secret") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(s.Close)
+
+	tmpDir := t.TempDir()
+	tokenFile := filepath.Join(tmpDir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0600))
+	emptyTokenFile := filepath.Join(tmpDir, "empty")
+	require.NoError(t, os.WriteFile(emptyTokenFile, nil, 0600))
+
+	tests := []struct {
+		name        string
+		credentials map[string]string
+		wantErr     assert.ErrorAssertionFunc
+		wantCallErr assert.ErrorAssertionFunc
+	}{
+		{name: "token", credentials: map[string]string{"token": "token"}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "invalid token", credentials: map[string]string{"token": "foo"}, wantErr: assert.NoError, wantCallErr: assert.Error},
+		{name: "token file", credentials: map[string]string{"tokenFile": tokenFile}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "token before token file", credentials: map[string]string{"token": "token", "tokenFile": emptyTokenFile}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "empty token file", credentials: map[string]string{"tokenFile": emptyTokenFile}, wantErr: assert.Error},
+		{name: "missing token file", credentials: map[string]string{"tokenFile": filepath.Join(tmpDir, "missing")}, wantErr: assert.Error},
+		{name: "basic auth", credentials: map[string]string{"username": "admin", "password": "secret"}, wantErr: assert.NoError, wantCallErr: assert.NoError},
+		{name: "invalid password", credentials: map[string]string{"username": "admin", "password": "foo"}, wantErr: assert.NoError, wantCallErr: assert.Error},
+		{name: "no credentials", wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("grafana.url", s.URL)
+			for key, value := range tt.credentials {
+				v.Set("grafana."+key, value)
+			}
+			c, err := configurationFromViper(v).grafanaClient()
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			_, err = c.Search.Search(search.NewSearchParams())
+			tt.wantCallErr(t, err)
+		})
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	const config = `
+namespace: monitoring
+tags: grope
+grafana:
+  url: http://localhost:3000
+  token: dev-token
+profiles:
+  production:
+    namespace: production
+    grafana:
+      url: https://grafana.example.com
+      token: prod-token
+      operator:
+        label:
+          name: instance
+          value: production
+`
+	tests := []struct {
+		name    string
+		profile string
+		flags   map[string]string
+		wantErr assert.ErrorAssertionFunc
+		want    configuration
+	}{
+		{
+			name:    "no profile",
+			wantErr: assert.NoError,
+			want: configuration{
+				Namespace: "monitoring",
+				Tags:      []string{"grope"},
+				Grafana: grafanaConfiguration{
+					URL:      "http://localhost:3000",
+					Token:    "dev-token",
+					Operator: grafanaOperatorConfiguration{Labels: map[string]string{"dashboards": "grafana"}},
+				},
+			},
+		},
+		{
+			name:    "profile",
+			profile: "production",
+			wantErr: assert.NoError,
+			want: configuration{
+				Namespace: "production",
+				Tags:      []string{"grope"},
+				Grafana: grafanaConfiguration{
+					URL:      "https://grafana.example.com",
+					Token:    "prod-token",
+					Operator: grafanaOperatorConfiguration{Labels: map[string]string{"instance": "production"}},
+				},
+			},
+		},
+		{
+			name:    "flags take precedence",
+			profile: "production",
+			flags:   map[string]string{"namespace": "override"},
+			wantErr: assert.NoError,
+			want: configuration{
+				Namespace: "override",
+				Tags:      []string{"grope"},
+				Grafana: grafanaConfiguration{
+					URL:      "https://grafana.example.com",
+					Token:    "prod-token",
+					Operator: grafanaOperatorConfiguration{Labels: map[string]string{"instance": "production"}},
+				},
+			},
+		},
+		{
+			name:    "unknown profile",
+			profile: "staging",
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			require.NoError(t, v.ReadConfig(bytes.NewBufferString(config)))
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.String("namespace", "", "")
+			require.NoError(t, v.BindPFlag("namespace", flags.Lookup("namespace")))
+			for flag, value := range tt.flags {
+				require.NoError(t, flags.Set(flag, value))
+			}
+			v.Set("profile", tt.profile)
+
+			err := applyProfile(v)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			cfg := configurationFromViper(v)
+			assert.Equal(t, tt.want.Namespace, cfg.Namespace)
+			assert.Equal(t, tt.want.Tags, cfg.Tags)
+			assert.Equal(t, tt.want.Grafana, cfg.Grafana)
+		})
+	}
+}
+
+func TestApplyProfile_Flag(t *testing.T) {
+	flag := rootCmd.PersistentFlags().Lookup("profile")
+	require.NotNil(t, flag)
+	require.NoError(t, rootCmd.PersistentFlags().Parse([]string{"--profile", "production"}))
+	t.Cleanup(func() {
+		_ = flag.Value.Set("")
+		flag.Changed = false
+	})
+	assert.Equal(t, "production", viper.GetString("profile"))
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(`
+namespace: monitoring
+profiles:
+  production:
+    namespace: production
+    grafana:
+      operator:
+        label:
+          name: instance
+          value: production
+`)))
+	require.NoError(t, v.BindPFlag("profile", flag))
+	require.NoError(t, applyProfile(v))
+	cfg := configurationFromViper(v)
+	assert.Equal(t, "production", cfg.Namespace)
+	assert.Equal(t, map[string]string{"instance": "production"}, cfg.Grafana.Operator.Labels)
+}
+
+func TestContinueOnError_Flag(t *testing.T) {
+	flag := rootCmd.PersistentFlags().Lookup("continue-on-error")
+	require.NotNil(t, flag)
+	require.NoError(t, rootCmd.PersistentFlags().Parse([]string{"--continue-on-error"}))
+	t.Cleanup(func() {
+		_ = flag.Value.Set("false")
+		flag.Changed = false
+	})
+	assert.True(t, configurationFromViper(viper.GetViper()).ContinueOnError)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	convertCmd = &cobra.Command{
+		Use:   "convert [flags] path [...]",
+		Short: "convert dashboard & datasource JSON files into grafana-operator custom resources",
+		Long: `Converts dashboard and datasource JSON files into grafana-operator custom resources, without connecting to Grafana.
+If a path is a directory, all .json files in that directory (and its subdirectories) are converted.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			// the converted files never cover all resources in Grafana, so stale manifests are never pruned.
+			return withManifestWriter(cfg, logger, nil, false, func(w manifestWriter) error {
+				return convertFiles(w, cfg, args, logger)
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+}
+
+func convertFiles(w manifestWriter, cfg configuration, paths []string, logger *slog.Logger) error {
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			// files passed explicitly are converted regardless of their extension.
+			if filepath.Ext(path) != ".json" && path != root {
+				return nil
+			}
+			if err = convertFile(w, cfg, path, logger); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertFile writes the custom resource for a dashboard or datasource JSON file.
+//
+// Dashboards can either be a dashboard model (as created by Grafana's Export dialog), or the dashboard as returned by
+// the Grafana API, i.e. the model & its metadata. Only the latter holds the dashboard's folder.
+func convertFile(w manifestWriter, cfg configuration, path string, logger *slog.Logger) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var content map[string]any
+	if err = json.Unmarshal(body, &content); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	switch {
+	case isDashboardModel(content["dashboard"]):
+		var dashboard models.DashboardFullWithMeta
+		if err = json.Unmarshal(body, &dashboard); err != nil {
+			return fmt.Errorf("json: %w", err)
+		}
+		return writeDashboard(w, cfg, dashboardHit(dashboard.Dashboard, dashboard.Meta), &dashboard, nil, logger)
+	case isDashboardModel(content):
+		dashboard := models.DashboardFullWithMeta{Dashboard: content}
+		return writeDashboard(w, cfg, dashboardHit(content, nil), &dashboard, nil, logger)
+	case content["type"] != nil && content["name"] != nil:
+		var datasource models.DataSource
+		if err = json.Unmarshal(body, &datasource); err != nil {
+			return fmt.Errorf("json: %w", err)
+		}
+		return writeDatasource(w, cfg, &datasource, logger)
+	default:
+		return errors.New("not a dashboard or datasource")
+	}
+}
+
+// isDashboardModel returns true if model looks like a dashboard model.
+func isDashboardModel(model any) bool {
+	dashboard, ok := model.(map[string]any)
+	if !ok {
+		return false
+	}
+	_, hasPanels := dashboard["panels"]
+	_, hasSchemaVersion := dashboard["schemaVersion"]
+	return hasPanels || hasSchemaVersion
+}
+
+// dashboardHit returns the search result that Grafana would have returned for the dashboard, so the dashboard
+// can be converted in the same way as an exported dashboard.
+func dashboardHit(dashboard any, meta *models.DashboardMeta) *models.Hit {
+	model, _ := dashboard.(map[string]any)
+	title, _ := model["title"].(string)
+	uid, _ := model["uid"].(string)
+	hit := models.Hit{Title: title, UID: uid, Type: "dash-db"}
+	// dashboards in the General folder have no folder UID.
+	if meta != nil && meta.FolderUID != "" {
+		hit.FolderTitle = meta.FolderTitle
+		hit.FolderUID = meta.FolderUID
+	}
+	return &hit
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gosimple/slug"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{name: "directory", paths: []string{"testdata/convert"}, wantErr: assert.NoError},
+		{name: "files", paths: []string{"testdata/convert/datasource.json", "testdata/convert/dashboard.json"}, wantErr: assert.NoError},
+		{name: "invalid file", paths: []string{"testdata/convert/README.md"}, wantErr: assert.Error},
+		{name: "missing file", paths: []string{"testdata/convert/missing.json"}, wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("namespace", "monitoring")
+			v.Set("tags", "grope")
+			cfg := configurationFromViper(v)
+
+			var buf bytes.Buffer
+			err := convertFiles(&streamWriter{w: &buf}, cfg, tt.paths, slog.New(slog.DiscardHandler))
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
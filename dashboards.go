@@ -2,13 +2,15 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"iter"
 	"log/slog"
-	"os"
-	"time"
+	"path"
+	"regexp"
+	"slices"
 
 	"codeberg.org/clambin/go-common/charmer"
 	"codeberg.org/clambin/go-common/set"
@@ -17,9 +19,10 @@ import (
 	"github.com/grafana/grafana-openapi-client-go/models"
 	"github.com/grafana/grafana-operator/v5/api/v1beta1"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/yaml" // use sigs.k8s.io/yaml as it contains magic to marshal k8s definitions to YAML
 )
 
 var (
@@ -28,62 +31,208 @@ var (
 		Short: "export Grafana dashboards",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg := configurationFromViper(viper.GetViper())
-			client, err := cfg.grafanaClient()
-			if err != nil {
-				return fmt.Errorf("grafana: %w", err)
-			}
-			return exportDashboards(os.Stdout, client, cfg, set.New(args...), charmer.GetLogger(cmd))
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, dashboardKinds(cfg), len(args) == 0 && cfg.DashboardFilter.empty(), func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportDashboards(w, client, cfg, set.New(args...), logger)
+				})
+			})
 		},
 	}
 )
 
+// dashboardFilterFlags holds the flags that select the dashboards. They are shared by all commands that select dashboards.
+var dashboardFilterFlags = pflag.NewFlagSet("dashboard filters", pflag.ContinueOnError)
+
 func init() {
+	dashboardFilterFlags.BoolP("folders", "f", false, "Select dashboards by folder title instead of dashboard title")
+	_ = viper.BindPFlag("folders", dashboardFilterFlags.Lookup("folders"))
+	dashboardFilterFlags.StringSlice("tag", nil, "Only include dashboards with all these tags")
+	_ = viper.BindPFlag("dashboards.include.tag", dashboardFilterFlags.Lookup("tag"))
+	dashboardFilterFlags.StringSlice("uid", nil, "Only include dashboards with these UIDs")
+	_ = viper.BindPFlag("dashboards.include.uid", dashboardFilterFlags.Lookup("uid"))
+	dashboardFilterFlags.String("title-regex", "", "Only include dashboards whose title matches this regular expression")
+	_ = viper.BindPFlag("dashboards.include.titleRegex", dashboardFilterFlags.Lookup("title-regex"))
+	dashboardFilterFlags.StringSlice("folder-glob", nil, "Only include dashboards in folders whose title matches one of these glob patterns")
+	_ = viper.BindPFlag("dashboards.include.folderGlob", dashboardFilterFlags.Lookup("folder-glob"))
+	dashboardFilterFlags.StringSlice("exclude-tag", nil, "Exclude dashboards with any of these tags")
+	_ = viper.BindPFlag("dashboards.exclude.tag", dashboardFilterFlags.Lookup("exclude-tag"))
+	dashboardFilterFlags.StringSlice("exclude-uid", nil, "Exclude dashboards with these UIDs")
+	_ = viper.BindPFlag("dashboards.exclude.uid", dashboardFilterFlags.Lookup("exclude-uid"))
+	dashboardFilterFlags.String("exclude-title-regex", "", "Exclude dashboards whose title matches this regular expression")
+	_ = viper.BindPFlag("dashboards.exclude.titleRegex", dashboardFilterFlags.Lookup("exclude-title-regex"))
+	dashboardFilterFlags.StringSlice("exclude-folder-glob", nil, "Exclude dashboards in folders whose title matches one of these glob patterns")
+	_ = viper.BindPFlag("dashboards.exclude.folderGlob", dashboardFilterFlags.Lookup("exclude-folder-glob"))
+	dashboardFilterFlags.Int("concurrency", defaultConcurrency, "Number of dashboards to retrieve in parallel")
+	_ = viper.BindPFlag("concurrency", dashboardFilterFlags.Lookup("concurrency"))
+
 	rootCmd.AddCommand(dashboardsCmd)
-	dashboardsCmd.Flags().BoolP("folders", "f", false, "Export folder")
-	_ = viper.BindPFlag("folders", dashboardsCmd.Flags().Lookup("folders"))
+	dashboardsCmd.Flags().AddFlagSet(dashboardFilterFlags)
+	dashboardsCmd.Flags().BoolP("library-panels", "l", false, "Export library panels used by the dashboards")
+	_ = viper.BindPFlag("library-panels", dashboardsCmd.Flags().Lookup("library-panels"))
+	dashboardsCmd.Flags().Bool("with-datasources", false, "Export the datasources used by the dashboards")
+	_ = viper.BindPFlag("with-datasources", dashboardsCmd.Flags().Lookup("with-datasources"))
+	rootCmd.PersistentFlags().Bool("config-maps", false, "Store each dashboard's JSON in a ConfigMap referenced by the GrafanaDashboard")
+	_ = viper.BindPFlag("config-maps", rootCmd.PersistentFlags().Lookup("config-maps"))
+	rootCmd.PersistentFlags().Bool("gzip", false, "Store dashboards larger than the gzip threshold gzip-compressed (ignored with --config-maps)")
+	_ = viper.BindPFlag("gzip.enabled", rootCmd.PersistentFlags().Lookup("gzip"))
+	rootCmd.PersistentFlags().Int("gzip-threshold", defaultGzipThreshold, "Size (in bytes) of a dashboard's JSON above which it is compressed")
+	_ = viper.BindPFlag("gzip.threshold", rootCmd.PersistentFlags().Lookup("gzip-threshold"))
+	rootCmd.PersistentFlags().Bool("template-datasources", false, "Replace the dashboards' datasource references by ${DS_<NAME>} inputs, mapped onto datasources by name")
+	_ = viper.BindPFlag("template-datasources", rootCmd.PersistentFlags().Lookup("template-datasources"))
+}
+
+// dashboardKinds returns the kinds of the custom resources of which exportDashboards creates a complete set.
+// Library panels and datasources aren't included: the export only creates those used by the exported dashboards.
+func dashboardKinds(cfg configuration) []string {
+	kinds := []string{"GrafanaDashboard"}
+	if cfg.ConfigMaps {
+		kinds = append(kinds, "ConfigMap")
+	}
+	return kinds
 }
 
 func exportDashboards(
-	w io.Writer,
+	w manifestWriter,
 	client *grafanaClient,
 	cfg configuration,
 	args set.Set[string],
 	logger *slog.Logger,
 ) error {
-	for entry, dashboard := range grafanaDashboards(client, cfg.Folders, args, logger) {
-		db, err := operatorDashboard(cfg, entry, dashboard)
-		if err != nil {
-			return fmt.Errorf("operator dashboard: %w", err)
+	var skipped skippedItems
+	var datasources map[string]string
+	if cfg.TemplateDatasources {
+		var err error
+		if datasources, err = grafanaDatasourceNames(client); err != nil {
+			return fmt.Errorf("list datasources: %w", err)
 		}
-		body, err := yaml.Marshal(db)
+	}
+	libraryPanels := set.New[string]()
+	datasourceRefs, exportedDatasources := set.New[string](), set.New[string]()
+	for db, err := range grafanaDashboards(client, cfg.Folders, args, cfg.DashboardFilter, cfg.Concurrency) {
 		if err != nil {
-			logger.Error("failed to marshal operator dashboard", "err", err)
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return err
+			}
+			continue
+		}
+		// get the datasource references before writeDashboard templates them.
+		var refs []datasourceRef
+		if cfg.WithDatasources {
+			refs = dashboardDatasourceRefs(db.dashboard.Dashboard)
+		}
+		if err = writeDashboard(w, cfg, db.entry, db.dashboard, datasources, logger); err != nil {
+			return err
+		}
+
+		for _, ref := range refs {
+			if datasourceRefs.Contains(ref.key) {
+				continue
+			}
+			datasourceRefs.Add(ref.key)
+			datasource, err := grafanaDatasourceByRef(client, ref)
+			if err != nil {
+				if err = skipped.skip(cfg, logger, fmt.Errorf("datasource %q: %w", ref.key, err)); err != nil {
+					return err
+				}
+				continue
+			}
+			// a datasource may be referred to both by name and by UID.
+			if exportedDatasources.Contains(datasource.UID) {
+				continue
+			}
+			exportedDatasources.Add(datasource.UID)
+			if err = writeDatasource(w, cfg, datasource, logger); err != nil {
+				return err
+			}
+		}
+
+		if !cfg.LibraryPanels {
+			continue
+		}
+		for _, uid := range libraryPanelUIDs(db.dashboard.Dashboard) {
+			if libraryPanels.Contains(uid) {
+				continue
+			}
+			libraryPanels.Add(uid)
+			panel, err := client.LibraryElements.GetLibraryElementByUID(uid)
+			if err != nil {
+				if err = skipped.skip(cfg, logger, fmt.Errorf("library panel %q: %w", uid, err)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = writeLibraryPanel(w, cfg, panel.GetPayload().Result); err != nil {
+				logger.Error("failed to write operator library panel", "err", err)
+				return err
+			}
+		}
+	}
+	return skipped.err()
+}
+
+// writeDashboard writes the GrafanaDashboard custom resource for a dashboard, preceded by its ConfigMap, if any.
+// datasources maps datasource UIDs onto their name (see templateDatasources).
+func writeDashboard(w manifestWriter, cfg configuration, entry *models.Hit, dashboard *models.DashboardFullWithMeta, datasources map[string]string, logger *slog.Logger) error {
+	db, cm, err := operatorDashboard(cfg, entry, dashboard, datasources)
+	if err != nil {
+		return fmt.Errorf("operator dashboard: %w", err)
+	}
+	if cm != nil {
+		if err = w.WriteManifest(cm.Kind, entry.FolderTitle, cm.Name, cm); err != nil {
+			logger.Error("failed to write dashboard config map", "err", err)
 			return err
 		}
-		_, _ = w.Write([]byte("---\n"))
-		_, _ = w.Write(body)
+	}
+	if err = w.WriteManifest(db.Kind, entry.FolderTitle, db.Name, db); err != nil {
+		logger.Error("failed to write operator dashboard", "err", err)
+		return err
 	}
 	return nil
 }
 
-// grafanaDashboards returns all Grafana dashboards that match args.
+// grafanaDashboard is a dashboard, together with its search result.
+type grafanaDashboard struct {
+	entry     *models.Hit
+	dashboard *models.DashboardFullWithMeta
+}
+
+// grafanaDashboards returns all Grafana dashboards that match args and the filter.
 // If folders is false, it returns all dashboards whose title matches an element of args.
 // Otherwise, it returns all dashboards in folders that matches an element of args.
-func grafanaDashboards(c *grafanaClient, folders bool, args set.Set[string], logger *slog.Logger) iter.Seq2[*models.Hit, *models.DashboardFullWithMeta] {
-	return func(yield func(*models.Hit, *models.DashboardFullWithMeta) bool) {
-		params := search.SearchParams{Type: constP("dash-db")}
+//
+// Dashboards are retrieved with up to concurrency parallel requests, but are returned in the order of the search results.
+// If a dashboard can't be retrieved, it yields an error and continues with the next dashboard.
+// If the search fails, it yields the error and stops.
+func grafanaDashboards(c *grafanaClient, folders bool, args set.Set[string], filter dashboardFilter, concurrency int) iter.Seq2[grafanaDashboard, error] {
+	return func(yield func(grafanaDashboard, error) bool) {
+		matches, err := filter.matcher()
+		if err != nil {
+			yield(grafanaDashboard{}, err)
+			return
+		}
+		params, err := filter.searchParams(c)
+		if err != nil {
+			yield(grafanaDashboard{}, err)
+			return
+		}
+		if params == nil {
+			// no folders match the filter.
+			return
+		}
 		var page int64
 		for page = 1; ; page++ {
 			params.Page = &page
-			ok, err := c.Search.Search(&params)
+			ok, err := c.Search.Search(params)
 			if err != nil {
-				logger.Error("Error getting dashboards", "err", err)
+				yield(grafanaDashboard{}, fmt.Errorf("search dashboards: %w", err))
 				return
 			}
 			hits := ok.GetPayload()
 			if len(hits) == 0 {
 				return
 			}
+			var selected []*models.Hit
 			for _, entry := range hits {
 				if len(args) > 0 {
 					if (!folders && !args.Contains(entry.Title)) ||
@@ -91,19 +240,174 @@ func grafanaDashboards(c *grafanaClient, folders bool, args set.Set[string], log
 						continue
 					}
 				}
-				db, err := c.Dashboards.GetDashboardByUID(entry.UID)
-				if err != nil {
-					logger.Error("Error getting dashboard", "err", err, "uid", entry.UID, "title", entry.Title)
+				if matches(entry) {
+					selected = append(selected, entry)
+				}
+			}
+			for db, err := range fetchDashboards(c, selected, concurrency) {
+				if !yield(db, err) {
 					return
 				}
-				if !yield(entry, db.GetPayload()) {
+			}
+		}
+	}
+}
+
+// dashboardFilter determines which dashboards to export. Dashboards must match all include filters.
+// An include filter with multiple values matches if any of the values match, except for tags: a dashboard must have all tags.
+// A dashboard matching any of the exclude filters is not exported.
+type dashboardFilter struct {
+	Tags               []string
+	UIDs               []string
+	TitleRegex         string
+	FolderGlobs        []string
+	ExcludeTags        []string
+	ExcludeUIDs        []string
+	ExcludeTitleRegex  string
+	ExcludeFolderGlobs []string
+}
+
+// empty returns true if the filter selects all dashboards.
+func (f dashboardFilter) empty() bool {
+	return len(f.Tags) == 0 && len(f.UIDs) == 0 && f.TitleRegex == "" && len(f.FolderGlobs) == 0 &&
+		len(f.ExcludeTags) == 0 && len(f.ExcludeUIDs) == 0 && f.ExcludeTitleRegex == "" && len(f.ExcludeFolderGlobs) == 0
+}
+
+// matcher returns a function that determines whether a search result matches the filter.
+func (f dashboardFilter) matcher() (func(*models.Hit) bool, error) {
+	titleRegex, err := compileRegex(f.TitleRegex)
+	if err != nil {
+		return nil, fmt.Errorf("title regex: %w", err)
+	}
+	excludeTitleRegex, err := compileRegex(f.ExcludeTitleRegex)
+	if err != nil {
+		return nil, fmt.Errorf("exclude title regex: %w", err)
+	}
+	for _, pattern := range slices.Concat(f.FolderGlobs, f.ExcludeFolderGlobs) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("folder glob %q: %w", pattern, err)
+		}
+	}
+
+	return func(entry *models.Hit) bool {
+		folder := folderTitle(entry.FolderTitle)
+		include := (len(f.UIDs) == 0 || slices.Contains(f.UIDs, entry.UID)) &&
+			(titleRegex == nil || titleRegex.MatchString(entry.Title)) &&
+			(len(f.FolderGlobs) == 0 || matchesGlob(f.FolderGlobs, folder)) &&
+			!slices.ContainsFunc(f.Tags, func(tag string) bool { return !slices.Contains(entry.Tags, tag) })
+		exclude := slices.Contains(f.ExcludeUIDs, entry.UID) ||
+			(excludeTitleRegex != nil && excludeTitleRegex.MatchString(entry.Title)) ||
+			matchesGlob(f.ExcludeFolderGlobs, folder) ||
+			slices.ContainsFunc(f.ExcludeTags, func(tag string) bool { return slices.Contains(entry.Tags, tag) })
+		return include && !exclude
+	}, nil
+}
+
+// searchParams returns the search parameters for the filter, so Grafana only returns dashboards we may export.
+// It returns nil if no dashboards can match, i.e. the filter has folder globs, but none of the folders match.
+func (f dashboardFilter) searchParams(c *grafanaClient) (*search.SearchParams, error) {
+	params := search.SearchParams{
+		Type:          constP("dash-db"),
+		Tag:           f.Tags,
+		DashboardUIDs: f.UIDs,
+	}
+	if len(f.FolderGlobs) == 0 {
+		return &params, nil
+	}
+	if matchesGlob(f.FolderGlobs, folderTitle("")) {
+		params.FolderUIDs = append(params.FolderUIDs, generalFolderUID)
+	}
+	_, err := walkFolders(c, nil, func(folder, _ *models.FolderSearchHit) bool {
+		if matchesGlob(f.FolderGlobs, folder.Title) {
+			params.FolderUIDs = append(params.FolderUIDs, folder.UID)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("folders: %w", err)
+	}
+	if len(params.FolderUIDs) == 0 {
+		return nil, nil
+	}
+	return &params, nil
+}
+
+// generalFolderUID is the UID used to search for dashboards in the General folder.
+const generalFolderUID = "general"
+
+// folderTitle returns the title of a dashboard's folder. Dashboards in the General folder have no folder title.
+func folderTitle(title string) string {
+	if title == "" {
+		return "General"
+	}
+	return title
+}
+
+func matchesGlob(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	})
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// fetchDashboards retrieves the dashboards for a list of search results, with up to concurrency parallel requests.
+// The dashboards are returned in the same order as the search results.
+func fetchDashboards(c *grafanaClient, entries []*models.Hit, concurrency int) iter.Seq2[grafanaDashboard, error] {
+	type result struct {
+		db  grafanaDashboard
+		err error
+	}
+	return func(yield func(grafanaDashboard, error) bool) {
+		// each request gets its own (buffered) channel, so we can return the results in order and requests never block.
+		results := make([]chan result, len(entries))
+		for i := range results {
+			results[i] = make(chan result, 1)
+		}
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			sem := make(chan struct{}, max(concurrency, 1))
+			for i, entry := range entries {
+				select {
+				case sem <- struct{}{}:
+				case <-done:
 					return
 				}
+				go func() {
+					defer func() { <-sem }()
+					dashboard, err := c.Dashboards.GetDashboardByUID(entry.UID)
+					if err != nil {
+						results[i] <- result{err: fmt.Errorf("dashboard %q (uid: %s): %w", entry.Title, entry.UID, err)}
+						return
+					}
+					results[i] <- result{db: grafanaDashboard{entry: entry, dashboard: dashboard.GetPayload()}}
+				}()
+			}
+		}()
+
+		for i := range results {
+			r := <-results[i]
+			if !yield(r.db, r.err) {
+				return
 			}
 		}
 	}
 }
 
+const (
+	folderModeTitle = "title"
+	folderModeRef   = "ref"
+	folderModeUID   = "uid"
+)
+
 // dashboardManifest is a stripped-down version of Grafana Operator Dashboard custom resource.
 // This allows us to marshal the dashboard to YAML without including the Status section.
 type dashboardManifest struct {
@@ -112,19 +416,45 @@ type dashboardManifest struct {
 	Spec              v1beta1.GrafanaDashboardSpec `json:"spec"`
 }
 
-func operatorDashboard(cfg configuration, entry *models.Hit, dashboard *models.DashboardFullWithMeta) (dashboardManifest, error) {
+// defaultConcurrency is the default number of dashboards retrieved in parallel.
+const defaultConcurrency = 4
+
+// defaultGzipThreshold is the default size above which a dashboard is compressed. This leaves ample room for the
+// rest of the custom resource below etcd's 1MiB object limit.
+const defaultGzipThreshold = 512 * 1024
+
+// dashboardConfigMapKey is the key holding the dashboard's JSON in its ConfigMap.
+const dashboardConfigMapKey = "dashboard.json"
+
+// operatorDashboard returns the GrafanaDashboard custom resource for a dashboard. If cfg.ConfigMaps is set,
+// the dashboard's JSON is stored in a ConfigMap with the same name, namespace and labels as the custom resource,
+// which refers to it through its configMapRef. Otherwise, the returned ConfigMap is nil.
+//
+// If cfg.Gzip is enabled, a dashboard whose JSON is larger than the threshold is stored in the custom resource's
+// gzipJson field instead.
+//
+// If cfg.Normalize is enabled, volatile fields are removed from the dashboard's model.
+//
+// If cfg.TemplateDatasources is set, the dashboard's datasource references are replaced by inputs, which the
+// custom resource maps onto the datasources' names. datasources maps datasource UIDs onto their name.
+func operatorDashboard(cfg configuration, entry *models.Hit, dashboard *models.DashboardFullWithMeta, datasources map[string]string) (dashboardManifest, *corev1.ConfigMap, error) {
 	if err := tagDashboard(dashboard, cfg.Tags...); err != nil {
-		return dashboardManifest{}, fmt.Errorf("failed to tag dashboard: %w", err)
+		return dashboardManifest{}, nil, fmt.Errorf("failed to tag dashboard: %w", err)
 	}
-
-	var encodedDashboard bytes.Buffer
-	jEnc := json.NewEncoder(&encodedDashboard)
-	jEnc.SetIndent("", "  ")
-	if err := jEnc.Encode(dashboard.Dashboard); err != nil {
-		return dashboardManifest{}, fmt.Errorf("json: %w", err)
+	if cfg.Normalize.Enabled {
+		if err := normalizeDashboard(dashboard, cfg.Normalize.Fields...); err != nil {
+			return dashboardManifest{}, nil, fmt.Errorf("failed to normalize dashboard: %w", err)
+		}
+	}
+	var inputs []v1beta1.GrafanaContentDatasource
+	if cfg.TemplateDatasources {
+		var err error
+		if inputs, err = templateDatasources(dashboard, datasources); err != nil {
+			return dashboardManifest{}, nil, fmt.Errorf("failed to template datasources: %w", err)
+		}
 	}
 
-	return dashboardManifest{
+	manifest := dashboardManifest{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: v1beta1.SchemeGroupVersion.String(),
 			Kind:       "GrafanaDashboard",
@@ -134,17 +464,86 @@ func operatorDashboard(cfg configuration, entry *models.Hit, dashboard *models.D
 			Namespace: cfg.Namespace,
 		},
 		Spec: v1beta1.GrafanaDashboardSpec{
-			GrafanaCommonSpec: v1beta1.GrafanaCommonSpec{
-				ResyncPeriod:              metav1.Duration{Duration: 10 * time.Minute},
-				AllowCrossNamespaceImport: true,
-				InstanceSelector:          cfg.instanceSelector(),
-			},
+			GrafanaCommonSpec: cfg.commonSpec(),
 			GrafanaContentSpec: v1beta1.GrafanaContentSpec{
-				JSON: encodedDashboard.String(),
+				Datasources: inputs,
 			},
-			FolderTitle: entry.FolderTitle,
 		},
-	}, nil
+	}
+
+	switch cfg.FolderMode {
+	case "", folderModeTitle:
+		manifest.Spec.FolderTitle = entry.FolderTitle
+	case folderModeRef:
+		// dashboards in the General folder have no folder resource to refer to.
+		if entry.FolderUID != "" {
+			manifest.Spec.FolderRef = folderResourceName(entry.FolderUID)
+		}
+	case folderModeUID:
+		manifest.Spec.FolderUID = entry.FolderUID
+	default:
+		return dashboardManifest{}, nil, fmt.Errorf("invalid folder mode %q", cfg.FolderMode)
+	}
+
+	var encodedDashboard bytes.Buffer
+	jEnc := json.NewEncoder(&encodedDashboard)
+	jEnc.SetIndent("", "  ")
+	if err := jEnc.Encode(dashboard.Dashboard); err != nil {
+		return dashboardManifest{}, nil, fmt.Errorf("json: %w", err)
+	}
+
+	if !cfg.ConfigMaps {
+		if cfg.Gzip.Enabled && encodedDashboard.Len() > cfg.Gzip.Threshold {
+			var err error
+			if manifest.Spec.GzipJSON, err = gzipBytes(encodedDashboard.Bytes()); err != nil {
+				return dashboardManifest{}, nil, fmt.Errorf("gzip: %w", err)
+			}
+		} else {
+			manifest.Spec.JSON = encodedDashboard.String()
+		}
+		return manifest, nil, nil
+	}
+
+	configMap := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      manifest.Name,
+			Namespace: manifest.Namespace,
+			Labels:    manifest.Labels,
+		},
+		Data: map[string]string{dashboardConfigMapKey: encodedDashboard.String()},
+	}
+	manifest.Spec.ConfigMapRef = &corev1.ConfigMapKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+		Key:                  dashboardConfigMapKey,
+	}
+	return manifest, &configMap, nil
+}
+
+// gzipBytes returns the gzip-compressed data.
+func gzipBytes(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// gunzipBytes returns the decompressed gzip data.
+func gunzipBytes(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
 }
 
 // tagDashboard adds tags to the dashboard's model.
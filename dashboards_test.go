@@ -7,7 +7,11 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"codeberg.org/clambin/go-common/set"
 	"github.com/gosimple/slug"
@@ -92,6 +96,69 @@ func TestExportDashboards(t *testing.T) {
 			},
 			wantErr: assert.NoError,
 		},
+		{
+			name: "folder reference",
+			config: func() *viper.Viper {
+				v := viper.New()
+				v.Set("grafana.url", "http://grafana")
+				v.Set("folder-mode", "ref")
+				return v
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "folder uid",
+			config: func() *viper.Viper {
+				v := viper.New()
+				v.Set("grafana.url", "http://grafana")
+				v.Set("folder-mode", "uid")
+				return v
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "config maps",
+			config: func() *viper.Viper {
+				v := viper.New()
+				v.Set("grafana.url", "http://grafana")
+				v.Set("namespace", "monitoring")
+				v.Set("config-maps", true)
+				return v
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "gzipped",
+			config: func() *viper.Viper {
+				v := viper.New()
+				v.Set("grafana.url", "http://grafana")
+				v.Set("gzip.enabled", true)
+				v.Set("gzip.threshold", 10)
+				return v
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "below gzip threshold",
+			config: func() *viper.Viper {
+				v := viper.New()
+				v.Set("grafana.url", "http://grafana")
+				v.Set("gzip.enabled", true)
+				v.Set("gzip.threshold", defaultGzipThreshold)
+				return v
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "invalid folder mode",
+			config: func() *viper.Viper {
+				v := viper.New()
+				v.Set("grafana.url", "http://grafana")
+				v.Set("folder-mode", "foo")
+				return v
+			},
+			wantErr: assert.Error,
+		},
 		{
 			name: "paged",
 			config: func() *viper.Viper {
@@ -112,8 +179,8 @@ func TestExportDashboards(t *testing.T) {
 				Search: fakeSearcher{
 					limit: tt.limit,
 					hitList: models.HitList{
-						{Title: "db 1", FolderTitle: "folder 1", Type: "dash-db", UID: "1"},
-						{Title: "db 2", FolderTitle: "folder 2", Type: "dash-db", UID: "2"},
+						{Title: "db 1", FolderTitle: "folder 1", FolderUID: "f1", Type: "dash-db", UID: "1"},
+						{Title: "db 2", FolderTitle: "folder 2", FolderUID: "f2", Type: "dash-db", UID: "2"},
 					},
 				},
 				Dashboards: fakeDashboardFetcher{dashboards: map[string]any{
@@ -123,7 +190,127 @@ func TestExportDashboards(t *testing.T) {
 			}
 
 			var buf bytes.Buffer
-			require.NoError(t, exportDashboards(&buf, &client, cfg, set.New(tt.args...), logger))
+			err := exportDashboards(&streamWriter{w: &buf}, &client, cfg, set.New(tt.args...), logger)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
+
+func TestExportDashboards_Errors(t *testing.T) {
+	tests := []struct {
+		name            string
+		searchErr       error
+		continueOnError bool
+		wantErr         string
+		wantDashboards  []string
+	}{
+		{
+			name:      "search fails",
+			searchErr: errors.New("server error"),
+			wantErr:   "search dashboards: server error",
+		},
+		{
+			name:    "dashboard fails",
+			wantErr: `dashboard "db 1" (uid: 1): dashboard not found`,
+		},
+		{
+			name:            "continue on error",
+			continueOnError: true,
+			wantErr:         "1 item(s) skipped:\n" + `dashboard "db 1" (uid: 1): dashboard not found`,
+			wantDashboards:  []string{"name: db-2"},
+		},
+		{
+			name:            "continue on search error",
+			searchErr:       errors.New("server error"),
+			continueOnError: true,
+			wantErr:         "1 item(s) skipped:\nsearch dashboards: server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("continue-on-error", tt.continueOnError)
+			client := grafanaClient{
+				Search: fakeSearcher{
+					err: tt.searchErr,
+					hitList: models.HitList{
+						{Title: "db 1", Type: "dash-db", UID: "1"},
+						{Title: "db 2", Type: "dash-db", UID: "2"},
+					},
+				},
+				Dashboards: fakeDashboardFetcher{dashboards: map[string]any{
+					"2": map[string]any{"foo": "bar", "tags": []any{}},
+				}},
+			}
+
+			var buf bytes.Buffer
+			err := exportDashboards(&streamWriter{w: &buf}, &client, configurationFromViper(v), set.New[string](), slog.New(slog.DiscardHandler))
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+			for _, want := range tt.wantDashboards {
+				assert.Contains(t, buf.String(), want)
+			}
+			if len(tt.wantDashboards) == 0 {
+				assert.Empty(t, buf.String())
+			}
+		})
+	}
+}
+
+func TestExportDashboards_WithDatasources(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(v *viper.Viper)
+	}{
+		{name: "bundled", config: func(v *viper.Viper) {}},
+		{name: "templated", config: func(v *viper.Viper) { v.Set("template-datasources", true) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.Set("grafana.url", "http://grafana")
+			v.Set("with-datasources", true)
+			tt.config(v)
+			client := grafanaClient{
+				Search: fakeSearcher{
+					hitList: models.HitList{
+						{Title: "db 1", Type: "dash-db", UID: "1"},
+						{Title: "db 2", Type: "dash-db", UID: "2"},
+					},
+				},
+				Dashboards: fakeDashboardFetcher{dashboards: map[string]any{
+					"1": map[string]any{"panels": []any{
+						map[string]any{"datasource": map[string]any{"type": "prometheus", "uid": "prom-1"}},
+						map[string]any{"datasource": "loki"},
+					}},
+					// prometheus, by name this time, is only exported once.
+					"2": map[string]any{"panels": []any{
+						map[string]any{"datasource": "prometheus"},
+						map[string]any{"datasource": map[string]any{"type": "datasource", "uid": "-- Mixed --"}},
+					}},
+				}},
+				Datasources: fakeDataSourceFetcher{dataSources: map[string]*models.DataSource{
+					"prometheus": {Name: "prometheus", UID: "prom-1", Type: "prometheus", URL: "http://prometheus"},
+					"loki":       {Name: "loki", UID: "loki-1", Type: "loki", URL: "http://loki"},
+					"unused":     {Name: "unused", UID: "unused-1", Type: "loki", URL: "http://loki-2"},
+				}},
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, exportDashboards(&streamWriter{w: &buf}, &client, configurationFromViper(v), set.New[string](), slog.New(slog.DiscardHandler)))
 
 			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
 			if *update {
@@ -136,6 +323,105 @@ func TestExportDashboards(t *testing.T) {
 	}
 }
 
+func TestGrafanaDashboards_Filter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  dashboardFilter
+		wantErr assert.ErrorAssertionFunc
+		want    []string
+	}{
+		{name: "no filter", wantErr: assert.NoError, want: []string{"1", "2", "3", "4"}},
+		{name: "tag", filter: dashboardFilter{Tags: []string{"prod"}}, wantErr: assert.NoError, want: []string{"1", "3"}},
+		{name: "all tags", filter: dashboardFilter{Tags: []string{"prod", "team-a"}}, wantErr: assert.NoError, want: []string{"1"}},
+		{name: "uid", filter: dashboardFilter{UIDs: []string{"2", "4"}}, wantErr: assert.NoError, want: []string{"2", "4"}},
+		{name: "title regex", filter: dashboardFilter{TitleRegex: "^(Node|Pod) "}, wantErr: assert.NoError, want: []string{"1", "2"}},
+		{name: "folder glob", filter: dashboardFilter{FolderGlobs: []string{"team-*"}}, wantErr: assert.NoError, want: []string{"1", "2", "3"}},
+		{name: "nested folder glob", filter: dashboardFilter{FolderGlobs: []string{"*-b"}}, wantErr: assert.NoError, want: []string{"3"}},
+		{name: "general folder", filter: dashboardFilter{FolderGlobs: []string{"General"}}, wantErr: assert.NoError, want: []string{"4"}},
+		{name: "no matching folders", filter: dashboardFilter{FolderGlobs: []string{"foo"}}, wantErr: assert.NoError},
+		{name: "combined", filter: dashboardFilter{Tags: []string{"prod"}, FolderGlobs: []string{"team-a"}}, wantErr: assert.NoError, want: []string{"1"}},
+		{name: "exclude tag", filter: dashboardFilter{ExcludeTags: []string{"team-a", "dev"}}, wantErr: assert.NoError, want: []string{"3", "4"}},
+		{name: "exclude uid", filter: dashboardFilter{ExcludeUIDs: []string{"1"}}, wantErr: assert.NoError, want: []string{"2", "3", "4"}},
+		{name: "exclude title regex", filter: dashboardFilter{ExcludeTitleRegex: "(?i)overview"}, wantErr: assert.NoError, want: []string{"1", "2", "3"}},
+		{name: "exclude folder glob", filter: dashboardFilter{FolderGlobs: []string{"*"}, ExcludeFolderGlobs: []string{"team-a"}}, wantErr: assert.NoError, want: []string{"3", "4"}},
+		{name: "invalid regex", filter: dashboardFilter{TitleRegex: "("}, wantErr: assert.Error},
+		{name: "invalid glob", filter: dashboardFilter{FolderGlobs: []string{"["}}, wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := grafanaClient{
+				Search: fakeSearcher{hitList: models.HitList{
+					{Title: "Node Exporter", FolderTitle: "team-a", FolderUID: "a", UID: "1", Tags: []string{"prod", "team-a"}},
+					{Title: "Pod Metrics", FolderTitle: "team-a", FolderUID: "a", UID: "2", Tags: []string{"dev", "team-a"}},
+					{Title: "Logs", FolderTitle: "team-b", FolderUID: "b", UID: "3", Tags: []string{"prod"}},
+					{Title: "Overview", UID: "4"},
+				}},
+				Dashboards: fakeDashboardFetcher{dashboards: map[string]any{"1": nil, "2": nil, "3": nil, "4": nil}},
+				Folders: fakeFolderFetcher{folders: map[string][]*models.FolderSearchHit{
+					"":  {{Title: "team-a", UID: "a"}},
+					"a": {{Title: "team-b", UID: "b", ParentUID: "a"}},
+				}},
+			}
+			var got []string
+			var err error
+			for db, dbErr := range grafanaDashboards(&client, false, set.New[string](), tt.filter, 1) {
+				if err = dbErr; err != nil {
+					break
+				}
+				got = append(got, db.entry.UID)
+			}
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGrafanaDashboards_Concurrency(t *testing.T) {
+	const count = 20
+	var hits models.HitList
+	dbs := make(map[string]any)
+	for i := range count {
+		uid := strconv.Itoa(i)
+		hits = append(hits, &models.Hit{Title: "db " + uid, Type: "dash-db", UID: uid})
+		dbs[uid] = map[string]any{"uid": uid}
+	}
+
+	for _, concurrency := range []int{1, 5} {
+		t.Run(strconv.Itoa(concurrency), func(t *testing.T) {
+			fetcher := slowDashboardFetcher{fakeDashboardFetcher: fakeDashboardFetcher{dashboards: dbs}}
+			client := grafanaClient{Search: fakeSearcher{hitList: hits}, Dashboards: &fetcher}
+
+			var uids []string
+			for db, err := range grafanaDashboards(&client, false, set.New[string](), dashboardFilter{}, concurrency) {
+				require.NoError(t, err)
+				uids = append(uids, db.entry.UID)
+			}
+			want := make([]string, count)
+			for i := range want {
+				want[i] = strconv.Itoa(i)
+			}
+			assert.Equal(t, want, uids)
+			// how many fetches overlap depends on scheduling, but never exceeds the configured concurrency.
+			assert.LessOrEqual(t, fetcher.maxInFlight.Load(), int32(concurrency))
+			if concurrency > 1 {
+				assert.Greater(t, fetcher.maxInFlight.Load(), int32(1))
+			}
+		})
+	}
+}
+
+func TestGrafanaDashboards_Stop(t *testing.T) {
+	hits := models.HitList{{Title: "db 1", UID: "1"}, {Title: "db 2", UID: "2"}, {Title: "db 3", UID: "3"}}
+	fetcher := slowDashboardFetcher{fakeDashboardFetcher: fakeDashboardFetcher{dashboards: map[string]any{"1": nil, "2": nil, "3": nil}}}
+	client := grafanaClient{Search: fakeSearcher{hitList: hits}, Dashboards: &fetcher}
+	for db, err := range grafanaDashboards(&client, false, set.New[string](), dashboardFilter{}, 2) {
+		require.NoError(t, err)
+		assert.Equal(t, "1", db.entry.UID)
+		break
+	}
+}
+
 func Test_tagDashboard(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -218,15 +504,53 @@ func (f fakeSearcher) Search(params *search.SearchParams, _ ...search.ClientOpti
 	if params.Limit != nil {
 		limit = *params.Limit
 	}
+	// apply the filters that grope pushes down to Grafana.
+	var hitList models.HitList
+	for _, hit := range f.hitList {
+		folderUID := hit.FolderUID
+		if folderUID == "" {
+			folderUID = generalFolderUID
+		}
+		if (len(params.DashboardUIDs) > 0 && !slices.Contains(params.DashboardUIDs, hit.UID)) ||
+			(len(params.FolderUIDs) > 0 && !slices.Contains(params.FolderUIDs, folderUID)) ||
+			slices.ContainsFunc(params.Tag, func(tag string) bool { return !slices.Contains(hit.Tags, tag) }) {
+			continue
+		}
+		hitList = append(hitList, hit)
+	}
 	start := int(page-1) * int(limit)
-	if start > len(f.hitList) {
+	if start > len(hitList) {
 		return result, nil
 	}
-	end := max(start+int(page), len(f.hitList))
-	result.Payload = f.hitList[start:end]
+	end := max(start+int(page), len(hitList))
+	result.Payload = hitList[start:end]
 	return result, f.err
 }
 
+var _ grafanaDashboardClient = &slowDashboardFetcher{}
+
+// slowDashboardFetcher returns dashboards with a delay. Lower UIDs take longer, so requests complete out of order.
+// It records the maximum number of parallel requests.
+type slowDashboardFetcher struct {
+	fakeDashboardFetcher
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (f *slowDashboardFetcher) GetDashboardByUID(dashboardUID string, opts ...dashboards.ClientOption) (*dashboards.GetDashboardByUIDOK, error) {
+	current := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		highest := f.maxInFlight.Load()
+		if current <= highest || f.maxInFlight.CompareAndSwap(highest, current) {
+			break
+		}
+	}
+	id, _ := strconv.Atoi(dashboardUID)
+	time.Sleep(time.Duration(25-id) * time.Millisecond)
+	return f.fakeDashboardFetcher.GetDashboardByUID(dashboardUID, opts...)
+}
+
 var _ grafanaDashboardClient = fakeDashboardFetcher{}
 
 type fakeDashboardFetcher struct {
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
+)
+
+// builtinDatasources are datasource references that don't refer to a datasource in Grafana.
+var builtinDatasources = set.New("-- Grafana --", "-- Mixed --", "-- Dashboard --", "grafana", "default")
+
+// walkDatasourceRefs calls visit for each datasource reference in a dashboard model: those of the panels (including
+// panels in (collapsed) rows), their targets, the template variables and the annotations.
+// The reference is replaced by the value returned by visit.
+//
+// A reference is either the datasource's name (older dashboards), or an object with the datasource's type and UID.
+func walkDatasourceRefs(dashboard any, visit func(ref any) any) {
+	replace := func(obj any) {
+		if o, ok := obj.(map[string]any); ok {
+			if ref, ok := o["datasource"]; ok && ref != nil {
+				o["datasource"] = visit(ref)
+			}
+		}
+	}
+	var walk func(panels any)
+	walk = func(panels any) {
+		panelList, _ := panels.([]any)
+		for _, p := range panelList {
+			panel, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			replace(panel)
+			targets, _ := panel["targets"].([]any)
+			for _, target := range targets {
+				replace(target)
+			}
+			walk(panel["panels"])
+		}
+	}
+	model, ok := dashboard.(map[string]any)
+	if !ok {
+		return
+	}
+	walk(model["panels"])
+	for _, section := range []string{"templating", "annotations"} {
+		if s, ok := model[section].(map[string]any); ok {
+			list, _ := s["list"].([]any)
+			for _, item := range list {
+				replace(item)
+			}
+		}
+	}
+}
+
+// datasourceRefKey returns the UID of the datasource referred to by ref or, for older dashboards, its name.
+// It returns false if ref doesn't refer to a datasource in Grafana: built-in datasources, template variables
+// or references that are already templated.
+func datasourceRefKey(ref any) (string, bool) {
+	var key string
+	switch r := ref.(type) {
+	case string:
+		key = r
+	case map[string]any:
+		if r["type"] == "datasource" {
+			return "", false
+		}
+		key, _ = r["uid"].(string)
+	}
+	if key == "" || strings.HasPrefix(key, "$") || builtinDatasources.Contains(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// datasourceRef is a reference to a datasource in Grafana, either by UID or (for older dashboards) by name.
+type datasourceRef struct {
+	key    string
+	byName bool
+}
+
+// dashboardDatasourceRefs returns the datasources referred to by a dashboard model. Each reference is returned once,
+// in order of appearance.
+func dashboardDatasourceRefs(dashboard any) []datasourceRef {
+	var refs []datasourceRef
+	seen := set.New[string]()
+	walkDatasourceRefs(dashboard, func(ref any) any {
+		if key, ok := datasourceRefKey(ref); ok && !seen.Contains(key) {
+			seen.Add(key)
+			_, byName := ref.(string)
+			refs = append(refs, datasourceRef{key: key, byName: byName})
+		}
+		return ref
+	})
+	return refs
+}
+
+// templateDatasources replaces the datasource references in the dashboard's model by ${DS_<NAME>} inputs and returns
+// the inputs, so the grafana-operator can map them onto the datasources with the same name in any Grafana instance.
+//
+// names maps datasource UIDs onto their name. References to datasources that aren't in names are assumed to refer
+// to the datasource by name.
+func templateDatasources(db *models.DashboardFullWithMeta, names map[string]string) ([]v1beta1.GrafanaContentDatasource, error) {
+	if _, ok := db.Dashboard.(map[string]any); !ok {
+		return nil, fmt.Errorf("unexpected model type: %T; expected map[string]any", db.Dashboard)
+	}
+	inputs := make(map[string]string) // datasource name -> input name
+	taken := make(map[string]string)  // input name -> datasource name
+	walkDatasourceRefs(db.Dashboard, func(ref any) any {
+		key, ok := datasourceRefKey(ref)
+		if !ok {
+			return ref
+		}
+		name := key
+		if n, ok := names[key]; ok {
+			name = n
+		}
+		input, ok := inputs[name]
+		if !ok {
+			input = datasourceInputName(name, taken)
+			inputs[name] = input
+			taken[input] = name
+		}
+		variable := "${" + input + "}"
+		if r, ok := ref.(map[string]any); ok {
+			r["uid"] = variable
+			return r
+		}
+		return variable
+	})
+
+	datasources := make([]v1beta1.GrafanaContentDatasource, 0, len(taken))
+	for input, name := range taken {
+		datasources = append(datasources, v1beta1.GrafanaContentDatasource{InputName: input, DatasourceName: name})
+	}
+	slices.SortFunc(datasources, func(a, b v1beta1.GrafanaContentDatasource) int {
+		return strings.Compare(a.InputName, b.InputName)
+	})
+	return datasources, nil
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// datasourceInputName returns the input name for a datasource, e.g. DS_PROMETHEUS_2 for "prometheus 2".
+// If the input name is already taken by another datasource, a sequence number is added.
+func datasourceInputName(name string, taken map[string]string) string {
+	input := "DS_" + strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+	candidate := input
+	for i := 2; ; i++ {
+		if _, ok := taken[candidate]; !ok {
+			return candidate
+		}
+		candidate = input + "_" + strconv.Itoa(i)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDashboardWithDatasources = `{
+  "title": "db 1",
+  "panels": [
+    {
+      "datasource": {"type": "prometheus", "uid": "prom-1"},
+      "targets": [
+        {"datasource": {"type": "prometheus", "uid": "prom-1"}, "expr": "up"},
+        {"datasource": {"type": "prometheus", "uid": "prom-2"}, "expr": "up"}
+      ]
+    },
+    {
+      "type": "row",
+      "panels": [
+        {"datasource": "loki", "targets": [{"expr": "{job=\"foo\"}"}]},
+        {"datasource": {"type": "datasource", "uid": "-- Mixed --"}}
+      ]
+    },
+    {"datasource": {"type": "prometheus", "uid": "${datasource}"}}
+  ],
+  "templating": {
+    "list": [
+      {"name": "datasource", "type": "datasource", "query": "prometheus"},
+      {"name": "job", "type": "query", "datasource": {"type": "prometheus", "uid": "prom-1"}}
+    ]
+  },
+  "annotations": {
+    "list": [
+      {"builtIn": 1, "datasource": {"type": "grafana", "uid": "-- Grafana --"}}
+    ]
+  }
+}`
+
+func TestTemplateDatasources(t *testing.T) {
+	var model map[string]any
+	require.NoError(t, json.Unmarshal([]byte(testDashboardWithDatasources), &model))
+	db := models.DashboardFullWithMeta{Dashboard: model}
+
+	inputs, err := templateDatasources(&db, map[string]string{"prom-1": "prometheus", "prom-2": "prometheus 2"})
+	require.NoError(t, err)
+	assert.Equal(t, []v1beta1.GrafanaContentDatasource{
+		{InputName: "DS_LOKI", DatasourceName: "loki"},
+		{InputName: "DS_PROMETHEUS", DatasourceName: "prometheus"},
+		{InputName: "DS_PROMETHEUS_2", DatasourceName: "prometheus 2"},
+	}, inputs)
+
+	var refs []any
+	walkDatasourceRefs(db.Dashboard, func(ref any) any {
+		refs = append(refs, ref)
+		return ref
+	})
+	assert.Equal(t, []any{
+		map[string]any{"type": "prometheus", "uid": "${DS_PROMETHEUS}"},
+		map[string]any{"type": "prometheus", "uid": "${DS_PROMETHEUS}"},
+		map[string]any{"type": "prometheus", "uid": "${DS_PROMETHEUS_2}"},
+		"${DS_LOKI}",
+		map[string]any{"type": "datasource", "uid": "-- Mixed --"},
+		map[string]any{"type": "prometheus", "uid": "${datasource}"},
+		map[string]any{"type": "prometheus", "uid": "${DS_PROMETHEUS}"},
+		map[string]any{"type": "grafana", "uid": "-- Grafana --"},
+	}, refs)
+}
+
+func TestTemplateDatasources_InvalidModel(t *testing.T) {
+	_, err := templateDatasources(&models.DashboardFullWithMeta{Dashboard: "foo"}, nil)
+	assert.Error(t, err)
+}
+
+func TestDatasourceInputName(t *testing.T) {
+	taken := map[string]string{"DS_PROM_1": "prom 1"}
+	assert.Equal(t, "DS_PROMETHEUS", datasourceInputName("prometheus", taken))
+	assert.Equal(t, "DS_MY_LOKI_EU", datasourceInputName("my-loki (eu)", taken))
+	assert.Equal(t, "DS_PROM_1_2", datasourceInputName("prom-1", taken))
+}
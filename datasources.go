@@ -3,81 +3,195 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"iter"
 	"log/slog"
-	"os"
-	"time"
+	"slices"
 
 	"codeberg.org/clambin/go-common/charmer"
 	"github.com/gosimple/slug"
 	"github.com/grafana/grafana-openapi-client-go/models"
 	"github.com/grafana/grafana-operator/v5/api/v1beta1"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/yaml"
 )
 
 var (
 	dataSourcesCmd = &cobra.Command{
-		Use:   "datasources <name> [ <name> ...]",
+		Use:   "datasources [flags] [name [...]]",
 		Short: "export Grafana data sources",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg := configurationFromViper(viper.GetViper())
-			client, err := cfg.grafanaClient()
-			if err != nil {
-				return fmt.Errorf("grafana: %w", err)
-			}
-			return exportDatasources(os.Stdout, client, cfg, args, charmer.GetLogger(cmd))
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaDatasource"}, len(args) == 0 && cfg.DatasourceFilter.empty(), func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportDatasources(w, client, cfg, args, logger)
+				})
+			})
 		},
 	}
 )
 
+// datasourceFilterFlags holds the flags that select the datasources. They are shared by all commands that select datasources.
+var datasourceFilterFlags = pflag.NewFlagSet("datasource filters", pflag.ContinueOnError)
+
 func init() {
+	datasourceFilterFlags.StringSlice("type", nil, "Only include datasources of these types")
+	_ = viper.BindPFlag("datasources.include.type", datasourceFilterFlags.Lookup("type"))
+	datasourceFilterFlags.StringSlice("uid", nil, "Only include datasources with these UIDs")
+	_ = viper.BindPFlag("datasources.include.uid", datasourceFilterFlags.Lookup("uid"))
+	datasourceFilterFlags.StringSlice("exclude-name", nil, "Exclude datasources with these names")
+	_ = viper.BindPFlag("datasources.exclude.name", datasourceFilterFlags.Lookup("exclude-name"))
+	datasourceFilterFlags.StringSlice("exclude-type", nil, "Exclude datasources of these types")
+	_ = viper.BindPFlag("datasources.exclude.type", datasourceFilterFlags.Lookup("exclude-type"))
+	datasourceFilterFlags.StringSlice("exclude-uid", nil, "Exclude datasources with these UIDs")
+	_ = viper.BindPFlag("datasources.exclude.uid", datasourceFilterFlags.Lookup("exclude-uid"))
+
 	rootCmd.AddCommand(dataSourcesCmd)
+	dataSourcesCmd.Flags().AddFlagSet(datasourceFilterFlags)
 }
 
 func exportDatasources(
-	w io.Writer,
+	w manifestWriter,
 	client *grafanaClient,
 	cfg configuration,
 	args []string,
 	logger *slog.Logger,
 ) error {
 
-	for datasource := range grafanaDataSources(client, args, logger) {
-		if len(datasource.SecureJSONFields) > 0 {
-			logger.Warn("datasource uses secure JSON fields and requires manual changes. See https://grafana.github.io/grafana-operator/docs/datasources/", "datasource", datasource.Name)
-		}
-		body, err := yaml.Marshal(operatorDatasource(cfg, datasource))
+	var skipped skippedItems
+	for datasource, err := range grafanaDataSources(client, args, cfg.DatasourceFilter) {
 		if err != nil {
-			logger.Error("failed to marshal operator datasource", "err", err)
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = writeDatasource(w, cfg, datasource, logger); err != nil {
 			return err
 		}
-		_, _ = w.Write([]byte("---\n"))
-		_, _ = w.Write(body)
+	}
+	return skipped.err()
+}
 
+// writeDatasource writes the GrafanaDatasource custom resource for a datasource.
+func writeDatasource(w manifestWriter, cfg configuration, datasource *models.DataSource, logger *slog.Logger) error {
+	if len(datasource.SecureJSONFields) > 0 {
+		logger.Warn("datasource uses secure JSON fields and requires manual changes. See https://grafana.github.io/grafana-operator/docs/datasources/", "datasource", datasource.Name)
+	}
+	manifest := operatorDatasource(cfg, datasource)
+	if err := w.WriteManifest(manifest.Kind, "", manifest.Name, manifest); err != nil {
+		logger.Error("failed to write operator datasource", "err", err)
+		return err
 	}
 	return nil
 }
 
-// grafanaDataSources returns all datasources that match the names in args.
-func grafanaDataSources(c *grafanaClient, args []string, logger *slog.Logger) iter.Seq[*models.DataSource] {
-	return func(yield func(*models.DataSource) bool) {
-		for _, name := range args {
-			ds, err := c.Datasources.GetDataSourceByName(name)
+// grafanaDataSources returns all datasources that match the names in args and the filter.
+// If args is empty, it considers all datasources.
+//
+// If a datasource can't be retrieved, it yields an error and continues with the next datasource.
+// If the datasources can't be listed, it yields the error and stops.
+func grafanaDataSources(c *grafanaClient, args []string, filter datasourceFilter) iter.Seq2[*models.DataSource, error] {
+	return func(yield func(*models.DataSource, error) bool) {
+		if len(args) > 0 {
+			for _, name := range args {
+				ds, err := c.Datasources.GetDataSourceByName(name)
+				if err != nil {
+					if !yield(nil, fmt.Errorf("datasource %q: %w", name, err)) {
+						return
+					}
+					continue
+				}
+				payload := ds.GetPayload()
+				if !filter.matches(payload.Name, payload.Type, payload.UID) {
+					continue
+				}
+				if !yield(payload, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		list, err := c.Datasources.GetDataSources()
+		if err != nil {
+			yield(nil, fmt.Errorf("list datasources: %w", err))
+			return
+		}
+		for _, entry := range list.GetPayload() {
+			if !filter.matches(entry.Name, entry.Type, entry.UID) {
+				continue
+			}
+			// the list doesn't contain all attributes (e.g. secureJsonFields), so get the full datasource.
+			ds, err := c.Datasources.GetDataSourceByUID(entry.UID)
 			if err != nil {
-				logger.Error("Error getting datasources", "name", name, "err", err)
+				if !yield(nil, fmt.Errorf("datasource %q (uid: %s): %w", entry.Name, entry.UID, err)) {
+					return
+				}
 				continue
 			}
-			if !yield(ds.GetPayload()) {
+			if !yield(ds.GetPayload(), nil) {
 				return
 			}
 		}
 	}
 }
 
+// grafanaDatasourceByRef returns the datasource referred to by a dashboard.
+func grafanaDatasourceByRef(c *grafanaClient, ref datasourceRef) (*models.DataSource, error) {
+	if ref.byName {
+		ds, err := c.Datasources.GetDataSourceByName(ref.key)
+		if err != nil {
+			return nil, err
+		}
+		return ds.GetPayload(), nil
+	}
+	ds, err := c.Datasources.GetDataSourceByUID(ref.key)
+	if err != nil {
+		return nil, err
+	}
+	return ds.GetPayload(), nil
+}
+
+// grafanaDatasourceNames returns the names of all Grafana datasources, keyed by their UID.
+func grafanaDatasourceNames(c *grafanaClient) (map[string]string, error) {
+	list, err := c.Datasources.GetDataSources()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(list.GetPayload()))
+	for _, entry := range list.GetPayload() {
+		names[entry.UID] = entry.Name
+	}
+	return names, nil
+}
+
+// datasourceFilter determines which datasources to export.
+// An empty include list matches all datasources. Exclude lists take precedence over include lists.
+type datasourceFilter struct {
+	Types        []string
+	UIDs         []string
+	ExcludeNames []string
+	ExcludeTypes []string
+	ExcludeUIDs  []string
+}
+
+// empty returns true if the filter selects all datasources.
+func (f datasourceFilter) empty() bool {
+	return len(f.Types) == 0 && len(f.UIDs) == 0 && len(f.ExcludeNames) == 0 && len(f.ExcludeTypes) == 0 && len(f.ExcludeUIDs) == 0
+}
+
+func (f datasourceFilter) matches(name, dsType, uid string) bool {
+	if (len(f.Types) > 0 && !slices.Contains(f.Types, dsType)) || (len(f.UIDs) > 0 && !slices.Contains(f.UIDs, uid)) {
+		return false
+	}
+	return !slices.Contains(f.ExcludeNames, name) &&
+		!slices.Contains(f.ExcludeTypes, dsType) &&
+		!slices.Contains(f.ExcludeUIDs, uid)
+}
+
 // datasourceManifest is a stripped-down version of Grafana Operator Datasource custom resource.
 // This allows us to marshal the datasource to YAML without including the Status section.
 type datasourceManifest struct {
@@ -101,11 +215,7 @@ func operatorDatasource(cfg configuration, datasource *models.DataSource) dataso
 			Namespace: cfg.Namespace,
 		},
 		Spec: v1beta1.GrafanaDatasourceSpec{
-			GrafanaCommonSpec: v1beta1.GrafanaCommonSpec{
-				ResyncPeriod:              metav1.Duration{Duration: 10 * time.Minute},
-				AllowCrossNamespaceImport: true,
-				InstanceSelector:          cfg.instanceSelector(),
-			},
+			GrafanaCommonSpec: cfg.commonSpec(),
 			// isn't there a way to get *GrafanaDatasourceInternal directly?
 			Datasource: &v1beta1.GrafanaDatasourceInternal{
 				UID:            datasource.UID,
@@ -118,7 +228,6 @@ func operatorDatasource(cfg configuration, datasource *models.DataSource) dataso
 				IsDefault:      &datasource.IsDefault,
 				BasicAuth:      &datasource.BasicAuth,
 				BasicAuthUser:  datasource.BasicAuthUser,
-				OrgID:          &datasource.OrgID,
 				Editable:       constP(false), // TODO: editable even if this is false.
 				JSONData:       jsonData,
 				SecureJSONData: nil, // unavailable from the grafana API.
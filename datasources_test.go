@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/gosimple/slug"
@@ -35,7 +37,7 @@ func TestExportDataSources(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	require.NoError(t, exportDatasources(&buf, &client, cfg, []string{"prometheus"}, logger))
+	require.NoError(t, exportDatasources(&streamWriter{w: &buf}, &client, cfg, []string{"prometheus"}, logger))
 
 	gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
 	if *update {
@@ -46,6 +48,97 @@ func TestExportDataSources(t *testing.T) {
 	assert.Equal(t, string(golden), buf.String())
 }
 
+func TestExportDataSources_NoArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(v *viper.Viper)
+	}{
+		{
+			name:   "unfiltered",
+			config: func(v *viper.Viper) {},
+		},
+		{
+			name: "include type",
+			config: func(v *viper.Viper) {
+				v.Set("datasources.include.type", []string{"loki"})
+			},
+		},
+		{
+			name: "include uid",
+			config: func(v *viper.Viper) {
+				v.Set("datasources.include.uid", []string{"prom-1", "loki-1"})
+			},
+		},
+		{
+			name: "exclude",
+			config: func(v *viper.Viper) {
+				v.Set("datasources.include.type", []string{"prometheus"})
+				v.Set("datasources.exclude.name", []string{"prometheus 2"})
+			},
+		},
+		{
+			name: "exclude type and uid",
+			config: func(v *viper.Viper) {
+				v.Set("datasources.exclude.type", []string{"loki"})
+				v.Set("datasources.exclude.uid", []string{"prom-2"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			v := viper.New()
+			v.Set("grafana.url", "http://grafana")
+			tt.config(v)
+			cfg := configurationFromViper(v)
+			client := grafanaClient{
+				Datasources: fakeDataSourceFetcher{
+					dataSources: map[string]*models.DataSource{
+						"prometheus":   {Name: "prometheus", UID: "prom-1", Type: "prometheus", URL: "http://prometheus"},
+						"prometheus 2": {Name: "prometheus 2", UID: "prom-2", Type: "prometheus", URL: "http://prometheus-2"},
+						"loki":         {Name: "loki", UID: "loki-1", Type: "loki", URL: "http://loki"},
+					},
+				},
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, exportDatasources(&streamWriter{w: &buf}, &client, cfg, nil, logger))
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
+
+func TestExportDataSources_Errors(t *testing.T) {
+	client := grafanaClient{
+		Datasources: fakeDataSourceFetcher{
+			dataSources: map[string]*models.DataSource{
+				"prometheus": {Name: "prometheus", Type: "prometheus", URL: "http://prometheus"},
+			},
+		},
+	}
+	args := []string{"loki", "prometheus"}
+
+	var buf bytes.Buffer
+	err := exportDatasources(&streamWriter{w: &buf}, &client, configuration{}, args, slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, `datasource "loki": not found`, err.Error())
+	assert.Empty(t, buf.String())
+
+	buf.Reset()
+	err = exportDatasources(&streamWriter{w: &buf}, &client, configuration{ContinueOnError: true}, args, slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "1 item(s) skipped:\n"+`datasource "loki": not found`, err.Error())
+	assert.Contains(t, buf.String(), "name: prometheus")
+}
+
 var _ grafanaDatasourcesClient = &fakeDataSourceFetcher{}
 
 type fakeDataSourceFetcher struct {
@@ -61,3 +154,23 @@ func (f fakeDataSourceFetcher) GetDataSourceByName(name string, _ ...datasources
 	}
 	return nil, errors.New("not found")
 }
+
+func (f fakeDataSourceFetcher) GetDataSourceByUID(uid string, _ ...datasources.ClientOption) (*datasources.GetDataSourceByUIDOK, error) {
+	for _, ds := range f.dataSources {
+		if ds.UID == uid {
+			result := datasources.NewGetDataSourceByUIDOK()
+			result.Payload = ds
+			return result, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (f fakeDataSourceFetcher) GetDataSources(_ ...datasources.ClientOption) (*datasources.GetDataSourcesOK, error) {
+	result := datasources.NewGetDataSourcesOK()
+	for _, name := range slices.Sorted(maps.Keys(f.dataSources)) {
+		ds := f.dataSources[name]
+		result.Payload = append(result.Payload, &models.DataSourceListItemDTO{Name: ds.Name, UID: ds.UID, Type: ds.Type})
+	}
+	return result, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"codeberg.org/clambin/go-common/set"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "compare Grafana with existing manifests",
+		Long: `Exports Grafana resources and compares the manifests with the existing manifests in a file or directory,
+without writing any files. Manifests are matched by kind, namespace and name. Dashboards are compared semantically,
+i.e. their JSON model is parsed rather than compared as a string.
+
+grope exits with a non-zero status if any manifests differ.`,
+	}
+	diffDashboardsCmd = &cobra.Command{
+		Use:   "dashboards [flags] [name [...]]",
+		Short: "compare Grafana dashboards with existing manifests",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			complete := len(args) == 0 && cfg.DashboardFilter.empty()
+			return withDiffWriter(cmd.OutOrStdout(), cfg, dashboardKinds(cfg), complete, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportDashboards(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+	diffDataSourcesCmd = &cobra.Command{
+		Use:   "datasources [flags] [name [...]]",
+		Short: "compare Grafana data sources with existing manifests",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			complete := len(args) == 0 && cfg.DatasourceFilter.empty()
+			return withDiffWriter(cmd.OutOrStdout(), cfg, []string{"GrafanaDatasource"}, complete, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportDatasources(w, client, cfg, args, logger)
+				})
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.PersistentFlags().String("against", "", "File or directory holding the existing manifests (default: the output directory)")
+	_ = viper.BindPFlag("diff.against", diffCmd.PersistentFlags().Lookup("against"))
+	diffCmd.PersistentFlags().String("diff-format", diffFormatUnified, "Diff format: unified or json")
+	_ = viper.BindPFlag("diff.format", diffCmd.PersistentFlags().Lookup("diff-format"))
+	diffCmd.AddCommand(diffDashboardsCmd, diffDataSourcesCmd)
+	diffDashboardsCmd.Flags().AddFlagSet(dashboardFilterFlags)
+	diffDataSourcesCmd.Flags().AddFlagSet(datasourceFilterFlags)
+}
+
+const (
+	diffFormatUnified = "unified"
+	diffFormatJSON    = "json"
+
+	diffAdded   = "added"
+	diffChanged = "changed"
+	diffRemoved = "removed"
+)
+
+type diffConfiguration struct {
+	Against string
+	Format  string
+}
+
+// withDiffWriter runs an export with a diffWriter, which compares the manifests with the existing manifests and
+// writes the differences to w. It returns an error if any manifests differ.
+//
+// If complete is true, the export covers all resources of its kinds, i.e. it isn't narrowed down by names or filters,
+// so existing manifests of those kinds that the export didn't create are reported as removed.
+func withDiffWriter(w io.Writer, cfg configuration, kinds []string, complete bool, export func(manifestWriter) error) error {
+	against := cfg.Diff.Against
+	if against == "" {
+		against = cfg.Output.Dir
+	}
+	if against == "" {
+		return errors.New("diff: no existing manifests to compare with: set --against or --output-dir")
+	}
+	d, err := newDiffWriter(w, against, cfg.Diff.Format, kinds, complete)
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	if err = export(d); err != nil {
+		return err
+	}
+	return d.Close()
+}
+
+var _ manifestWriter = &diffWriter{}
+
+// diffWriter compares manifests with the existing manifests, rather than writing them.
+type diffWriter struct {
+	w         io.Writer
+	format    string
+	kinds     set.Set[string]
+	complete  bool
+	existing  map[string]map[string]any
+	generated set.Set[string]
+	diffs     []manifestDiff
+}
+
+// manifestDiff is the difference between a generated manifest and the existing one.
+type manifestDiff struct {
+	Manifest  string           `json:"manifest"`
+	Status    string           `json:"status"`
+	Changes   []manifestChange `json:"changes,omitempty"`
+	existing  map[string]any
+	generated map[string]any
+}
+
+// manifestChange is a changed value in a manifest. Path is the value's location, e.g. spec.json.panels[0].title.
+type manifestChange struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+func newDiffWriter(w io.Writer, against, format string, kinds []string, complete bool) (*diffWriter, error) {
+	switch format {
+	case "":
+		format = diffFormatUnified
+	case diffFormatUnified, diffFormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid diff format %q", format)
+	}
+	existing := make(map[string]map[string]any)
+	err := readManifests(against, func(_ string, document []byte) error {
+		obj, err := parseManifest(document)
+		if err != nil {
+			return err
+		}
+		if obj["kind"] != nil {
+			existing[manifestKey(obj)] = obj
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &diffWriter{
+		w:         w,
+		format:    format,
+		kinds:     set.New(kinds...),
+		complete:  complete,
+		existing:  existing,
+		generated: set.New[string](),
+	}, nil
+}
+
+func (d *diffWriter) WriteManifest(_, _, _ string, manifest any) error {
+	body, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	obj, err := parseManifest(body)
+	if err != nil {
+		return err
+	}
+	key := manifestKey(obj)
+	d.generated.Add(key)
+	existing, ok := d.existing[key]
+	switch {
+	case !ok:
+		d.diffs = append(d.diffs, manifestDiff{Manifest: key, Status: diffAdded, generated: obj})
+	case !reflect.DeepEqual(existing, obj):
+		var changes []manifestChange
+		objectChanges("", existing, obj, &changes)
+		d.diffs = append(d.diffs, manifestDiff{Manifest: key, Status: diffChanged, Changes: changes, existing: existing, generated: obj})
+	}
+	return nil
+}
+
+// Close writes the differences and returns an error if any manifests differ.
+func (d *diffWriter) Close() error {
+	if d.complete {
+		for _, key := range slices.Sorted(maps.Keys(d.existing)) {
+			obj := d.existing[key]
+			kind, _ := obj["kind"].(string)
+			if d.kinds.Contains(kind) && !d.generated.Contains(key) {
+				d.diffs = append(d.diffs, manifestDiff{Manifest: key, Status: diffRemoved, existing: obj})
+			}
+		}
+	}
+	var err error
+	switch d.format {
+	case diffFormatJSON:
+		err = d.writeJSON()
+	default:
+		err = d.writeUnified()
+	}
+	if err != nil {
+		return err
+	}
+	if len(d.diffs) > 0 {
+		return fmt.Errorf("%d manifest(s) differ", len(d.diffs))
+	}
+	return nil
+}
+
+func (d *diffWriter) writeJSON() error {
+	diffs := d.diffs
+	if diffs == nil {
+		diffs = []manifestDiff{}
+	}
+	enc := json.NewEncoder(d.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diffs)
+}
+
+func (d *diffWriter) writeUnified() error {
+	for _, diff := range d.diffs {
+		from, to := "a/"+diff.Manifest, "b/"+diff.Manifest
+		var existing, generated []byte
+		var err error
+		if diff.existing != nil {
+			if existing, err = yaml.Marshal(diff.existing); err != nil {
+				return err
+			}
+		} else {
+			from = "/dev/null"
+		}
+		if diff.generated != nil {
+			if generated, err = yaml.Marshal(diff.generated); err != nil {
+				return err
+			}
+		} else {
+			to = "/dev/null"
+		}
+		if err = difflib.WriteUnifiedDiff(d.w, difflib.UnifiedDiff{
+			A:        diffLines(existing),
+			B:        diffLines(generated),
+			FromFile: from,
+			ToFile:   to,
+			Context:  3,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffLines splits a YAML document into lines, keeping their line endings.
+func diffLines(document []byte) []string {
+	lines := strings.SplitAfter(string(document), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// parseManifest parses a manifest into a generic object. The JSON models of dashboards and library panels, whether
+// they are held in the custom resource (compressed or not) or in a ConfigMap, are parsed as well, so they can be
+// compared semantically.
+func parseManifest(body []byte) (map[string]any, error) {
+	var obj map[string]any
+	if err := yaml.Unmarshal(body, &obj); err != nil {
+		return nil, err
+	}
+	spec, _ := obj["spec"].(map[string]any)
+	switch obj["kind"] {
+	case "GrafanaDashboard", "GrafanaLibraryPanel":
+		if model, ok := spec["json"].(string); ok {
+			var parsed any
+			if err := json.Unmarshal([]byte(model), &parsed); err != nil {
+				return nil, fmt.Errorf("spec.json: %w", err)
+			}
+			spec["json"] = parsed
+		}
+		if encoded, ok := spec["gzipJson"].(string); ok {
+			compressed, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("spec.gzipJson: %w", err)
+			}
+			model, err := gunzipBytes(compressed)
+			if err != nil {
+				return nil, fmt.Errorf("spec.gzipJson: %w", err)
+			}
+			var parsed any
+			if err = json.Unmarshal(model, &parsed); err != nil {
+				return nil, fmt.Errorf("spec.gzipJson: %w", err)
+			}
+			spec["gzipJson"] = parsed
+		}
+	case "ConfigMap":
+		data, _ := obj["data"].(map[string]any)
+		if model, ok := data[dashboardConfigMapKey].(string); ok {
+			var parsed any
+			if err := json.Unmarshal([]byte(model), &parsed); err != nil {
+				return nil, fmt.Errorf("data.%s: %w", dashboardConfigMapKey, err)
+			}
+			data[dashboardConfigMapKey] = parsed
+		}
+	}
+	return obj, nil
+}
+
+// manifestKey identifies a manifest by its kind, namespace and name, e.g. GrafanaDashboard/monitoring/my-dashboard.
+func manifestKey(obj map[string]any) string {
+	kind, _ := obj["kind"].(string)
+	metadata, _ := obj["metadata"].(map[string]any)
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+	if namespace == "" {
+		return kind + "/" + name
+	}
+	return kind + "/" + namespace + "/" + name
+}
+
+// objectChanges adds the values that differ between a and b to changes.
+func objectChanges(path string, a, b any, changes *[]manifestChange) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			keys := set.New(slices.Collect(maps.Keys(av))...)
+			for key := range bv {
+				keys.Add(key)
+			}
+			for _, key := range keys.ListOrdered() {
+				p := key
+				if path != "" {
+					p = path + "." + key
+				}
+				objectChanges(p, av[key], bv[key], changes)
+			}
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			for i := range max(len(av), len(bv)) {
+				var ai, bi any
+				if i < len(av) {
+					ai = av[i]
+				}
+				if i < len(bv) {
+					bi = bv[i]
+				}
+				objectChanges(fmt.Sprintf("%s[%d]", path, i), ai, bi, changes)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, manifestChange{Path: path, Old: a, New: b})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffWriter(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+	v := viper.New()
+	v.Set("grafana.url", "http://grafana")
+	v.Set("output.dir", dir)
+	cfg := configurationFromViper(v)
+	client := func(dashboards map[string]any) *grafanaClient {
+		var hits models.HitList
+		for _, uid := range slices.Sorted(maps.Keys(dashboards)) {
+			hits = append(hits, &models.Hit{Title: "db " + uid, Type: "dash-db", UID: uid})
+		}
+		return &grafanaClient{
+			Search:     fakeSearcher{hitList: hits},
+			Dashboards: fakeDashboardFetcher{dashboards: dashboards},
+		}
+	}
+
+	// create the existing manifests
+	require.NoError(t, withManifestWriter(cfg, logger, dashboardKinds(cfg), true, func(w manifestWriter) error {
+		return exportDashboards(w, client(map[string]any{
+			"1": map[string]any{"title": "db 1", "tags": []any{}},
+			"2": map[string]any{"title": "db 2", "tags": []any{}},
+		}), cfg, set.New[string](), logger)
+	}))
+
+	tests := []struct {
+		name       string
+		format     string
+		dashboards map[string]any
+		wantErr    string
+		want       string
+	}{
+		{
+			name: "no drift",
+			dashboards: map[string]any{
+				"1": map[string]any{"title": "db 1", "tags": []any{}},
+				"2": map[string]any{"title": "db 2", "tags": []any{}},
+			},
+		},
+		{
+			name: "unified",
+			dashboards: map[string]any{
+				"2": map[string]any{"title": "db 2 (new)", "tags": []any{}},
+				"3": map[string]any{"title": "db 3", "tags": []any{}},
+			},
+			wantErr: "3 manifest(s) differ",
+			want: `--- a/GrafanaDashboard/db-2
++++ b/GrafanaDashboard/db-2
+@@ -10,5 +10,5 @@
+       dashboards: grafana
+   json:
+     tags: []
+-    title: db 2
++    title: db 2 (new)
+   resyncPeriod: 10m0s
+--- /dev/null
++++ b/GrafanaDashboard/db-3
+@@ -0,0 +1,14 @@
++apiVersion: grafana.integreatly.org/v1beta1
++kind: GrafanaDashboard
++metadata:
++  name: db-3
++spec:
++  allowCrossNamespaceImport: true
++  contentCacheDuration: 0s
++  instanceSelector:
++    matchLabels:
++      dashboards: grafana
++  json:
++    tags: []
++    title: db 3
++  resyncPeriod: 10m0s
+--- a/GrafanaDashboard/db-1
++++ /dev/null
+@@ -1,14 +0,0 @@
+-apiVersion: grafana.integreatly.org/v1beta1
+-kind: GrafanaDashboard
+-metadata:
+-  name: db-1
+-spec:
+-  allowCrossNamespaceImport: true
+-  contentCacheDuration: 0s
+-  instanceSelector:
+-    matchLabels:
+-      dashboards: grafana
+-  json:
+-    tags: []
+-    title: db 1
+-  resyncPeriod: 10m0s
+`,
+		},
+		{
+			name:   "json",
+			format: diffFormatJSON,
+			dashboards: map[string]any{
+				"1": map[string]any{"title": "db 1", "tags": []any{}},
+				"2": map[string]any{"title": "db 2", "tags": []any{"new"}},
+			},
+			wantErr: "1 manifest(s) differ",
+			want: `[
+  {
+    "manifest": "GrafanaDashboard/db-2",
+    "status": "changed",
+    "changes": [
+      {
+        "path": "spec.json.tags[0]",
+        "new": "new"
+      }
+    ]
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.Set("diff.format", tt.format)
+			cfg := configurationFromViper(v)
+			var buf bytes.Buffer
+			err := withDiffWriter(&buf, cfg, dashboardKinds(cfg), true, func(w manifestWriter) error {
+				return exportDashboards(w, client(tt.dashboards), cfg, set.New[string](), logger)
+			})
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantErr, err.Error())
+			}
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestDiffWriter_Semantic(t *testing.T) {
+	// the existing manifest's dashboard JSON is formatted differently, but has the same content.
+	// other kinds, like the library panels written by the library-panels command, aren't reported as removed.
+	existing := filepath.Join(t.TempDir(), "dashboards.yaml")
+	require.NoError(t, os.WriteFile(existing, []byte(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+---
+apiVersion: grafana.integreatly.org/v1beta1
+kind: GrafanaLibraryPanel
+metadata:
+  name: panel-1
+---
+apiVersion: grafana.integreatly.org/v1beta1
+kind: GrafanaDashboard
+metadata:
+  name: db-1
+spec:
+  allowCrossNamespaceImport: true
+  contentCacheDuration: 0s
+  instanceSelector:
+    matchLabels:
+      dashboards: grafana
+  json: '{"title":"db 1","tags":[]}'
+  resyncPeriod: 10m0s
+`), 0644))
+
+	v := viper.New()
+	v.Set("grafana.url", "http://grafana")
+	v.Set("diff.against", existing)
+	cfg := configurationFromViper(v)
+	client := grafanaClient{
+		Search:     fakeSearcher{hitList: models.HitList{{Title: "db 1", Type: "dash-db", UID: "1"}}},
+		Dashboards: fakeDashboardFetcher{dashboards: map[string]any{"1": map[string]any{"tags": []any{}, "title": "db 1"}}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, withDiffWriter(&buf, cfg, dashboardKinds(cfg), true, func(w manifestWriter) error {
+		return exportDashboards(w, &client, cfg, set.New[string](), slog.New(slog.DiscardHandler))
+	}))
+	assert.Empty(t, buf.String())
+}
+
+func TestDiffWriter_Errors(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("kind: GrafanaDashboard\nspec:\n  json: '{'\n"), 0644))
+
+	tests := []struct {
+		name    string
+		against string
+		format  string
+		wantErr string
+	}{
+		{name: "no existing manifests", wantErr: "diff: no existing manifests to compare with: set --against or --output-dir"},
+		{name: "missing", against: filepath.Join(t.TempDir(), "missing"), wantErr: "no such file or directory"},
+		{name: "invalid manifest", against: invalid, wantErr: "spec.json: unexpected end of JSON input"},
+		{name: "invalid format", against: t.TempDir(), format: "yaml", wantErr: `diff: invalid diff format "yaml"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := configuration{Diff: diffConfiguration{Against: tt.against, Format: tt.format}}
+			err := withDiffWriter(&bytes.Buffer{}, cfg, nil, true, func(manifestWriter) error { return nil })
+			require.Error(t, err)
+			assert.True(t, strings.HasSuffix(err.Error(), tt.wantErr), err.Error())
+		})
+	}
+}
+
+func TestObjectChanges(t *testing.T) {
+	var a, b any
+	require.NoError(t, json.Unmarshal([]byte(`{"a": 1, "b": {"c": [1, 2]}, "d": "x"}`), &a))
+	require.NoError(t, json.Unmarshal([]byte(`{"a": 1, "b": {"c": [1, 3, 4]}, "e": "y"}`), &b))
+	var changes []manifestChange
+	objectChanges("", a, b, &changes)
+	assert.Equal(t, []manifestChange{
+		{Path: "b.c[1]", Old: 2.0, New: 3.0},
+		{Path: "b.c[2]", New: 4.0},
+		{Path: "d", Old: "x"},
+		{Path: "e", New: "y"},
+	}, changes)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"iter"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"codeberg.org/clambin/go-common/set"
+	"github.com/grafana/grafana-openapi-client-go/client/folders"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	foldersCmd = &cobra.Command{
+		Use:   "folders [flags] [title [...]]",
+		Short: "export Grafana folders",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaFolder"}, len(args) == 0, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportFolders(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(foldersCmd)
+}
+
+func exportFolders(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	args set.Set[string],
+	logger *slog.Logger,
+) error {
+	var skipped skippedItems
+	for folder, err := range grafanaFolders(client) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(args) > 0 && !args.Contains(folder.folder.Title) {
+			continue
+		}
+		// only refer to the parent by name if we also export it. Otherwise, fall back to its UID.
+		exportedParent := folder.parent != nil && (len(args) == 0 || args.Contains(folder.parent.Title))
+		manifest := operatorFolder(cfg, folder.folder, folder.parent, exportedParent)
+		if err := w.WriteManifest(manifest.Kind, "", manifest.Name, manifest); err != nil {
+			logger.Error("failed to write operator folder", "err", err)
+			return err
+		}
+	}
+	return skipped.err()
+}
+
+// grafanaFolder is a Grafana folder, together with its parent folder (nil for top-level folders).
+type grafanaFolder struct {
+	folder *models.FolderSearchHit
+	parent *models.FolderSearchHit
+}
+
+// grafanaFolders returns all Grafana folders. Folders are walked depth-first, so a parent is always returned
+// before any of its subfolders. If the folders can't be retrieved, it returns an error after the folders
+// retrieved so far.
+func grafanaFolders(c *grafanaClient) iter.Seq2[grafanaFolder, error] {
+	return func(yield func(grafanaFolder, error) bool) {
+		_, err := walkFolders(c, nil, func(folder, parent *models.FolderSearchHit) bool {
+			return yield(grafanaFolder{folder: folder, parent: parent}, nil)
+		})
+		if err != nil {
+			yield(grafanaFolder{}, fmt.Errorf("list folders: %w", err))
+		}
+	}
+}
+
+// walkFolders yields all subfolders of parent (or all top-level folders if parent is nil) and recursively their subfolders.
+// It returns false if the caller stopped the iteration, or if the folders couldn't be retrieved.
+func walkFolders(c *grafanaClient, parent *models.FolderSearchHit, yield func(*models.FolderSearchHit, *models.FolderSearchHit) bool) (bool, error) {
+	params := folders.NewGetFoldersParams()
+	if parent != nil {
+		params.ParentUID = &parent.UID
+	}
+	var page int64
+	for page = 1; ; page++ {
+		params.Page = &page
+		ok, err := c.Folders.GetFolders(params)
+		if err != nil {
+			return false, err
+		}
+		hits := ok.GetPayload()
+		if len(hits) == 0 {
+			return true, nil
+		}
+		for _, folder := range hits {
+			if !yield(folder, parent) {
+				return false, nil
+			}
+			if more, err := walkFolders(c, folder, yield); !more {
+				return false, err
+			}
+		}
+	}
+}
+
+// folderManifest is a GrafanaFolder custom resource without its Status section.
+type folderManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaFolderSpec `json:"spec"`
+}
+
+func operatorFolder(cfg configuration, folder, parent *models.FolderSearchHit, parentExported bool) folderManifest {
+	manifest := folderManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaFolder",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      folderResourceName(folder.UID),
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaFolderSpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			CustomUID:         folder.UID,
+			Title:             folder.Title,
+		},
+	}
+	if parent != nil {
+		if parentExported {
+			manifest.Spec.ParentFolderRef = folderResourceName(parent.UID)
+		} else {
+			manifest.Spec.ParentFolderUID = parent.UID
+		}
+	}
+	return manifest
+}
+
+// folderResourceName returns the name of the GrafanaFolder resource for a folder.
+// Dashboards use this to refer to their folder's resource. The name is derived from the folder's UID,
+// as folder titles are only unique within their parent folder.
+//
+// UIDs are case-sensitive and may contain underscores, neither of which are allowed in a resource name.
+// Such UIDs are lowercased, with any invalid characters replaced by dashes, and get a hash of the UID
+// as a suffix, so UIDs that only differ in case still get different names.
+func folderResourceName(uid string) string {
+	if len(validation.IsDNS1123Label(uid)) == 0 {
+		return uid
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		default:
+			return '-'
+		}
+	}, uid)
+	hash := sha256.Sum256([]byte(uid))
+	suffix := hex.EncodeToString(hash[:4])
+	if name = strings.Trim(name, "-"); name == "" {
+		return suffix
+	}
+	return name + "-" + suffix
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/client/folders"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestExportFolders(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "unfiltered"},
+		{name: "filtered", args: []string{"folder 1.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			v := viper.New()
+			v.Set("grafana.url", "http://grafana")
+			v.Set("namespace", "monitoring")
+			cfg := configurationFromViper(v)
+			client := grafanaClient{
+				Folders: fakeFolderFetcher{folders: map[string][]*models.FolderSearchHit{
+					"": {
+						{Title: "folder 1", UID: "f1"},
+						{Title: "folder 2", UID: "f2"},
+					},
+					"f1": {
+						{Title: "folder 1.1", UID: "f11", ParentUID: "f1"},
+						{Title: "alerts", UID: "f1-alerts", ParentUID: "f1"},
+					},
+					"f2": {
+						{Title: "alerts", UID: "f2-alerts", ParentUID: "f2"},
+					},
+				}},
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, exportFolders(&streamWriter{w: &buf}, &client, cfg, set.New(tt.args...), logger))
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
+
+func TestExportFolders_Error(t *testing.T) {
+	client := grafanaClient{Folders: fakeFolderFetcher{err: errors.New("server error")}}
+	err := exportFolders(&streamWriter{w: &bytes.Buffer{}}, &client, configuration{}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "list folders: server error", err.Error())
+}
+
+var _ grafanaFoldersClient = fakeFolderFetcher{}
+
+type fakeFolderFetcher struct {
+	folders map[string][]*models.FolderSearchHit
+	err     error
+}
+
+func (f fakeFolderFetcher) GetFolders(params *folders.GetFoldersParams, _ ...folders.ClientOption) (*folders.GetFoldersOK, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := folders.NewGetFoldersOK()
+	// fake only supports a single page
+	if params.Page != nil && *params.Page > 1 {
+		return result, nil
+	}
+	var parent string
+	if params.ParentUID != nil {
+		parent = *params.ParentUID
+	}
+	result.Payload = f.folders[parent]
+	return result, nil
+}
+
+func (f fakeFolderFetcher) GetFolderByUID(uid string, _ ...folders.ClientOption) (*folders.GetFolderByUIDOK, error) {
+	for _, hits := range f.folders {
+		for _, hit := range hits {
+			if hit.UID == uid {
+				result := folders.NewGetFolderByUIDOK()
+				result.Payload = &models.Folder{UID: hit.UID, Title: hit.Title, ParentUID: hit.ParentUID}
+				return result, nil
+			}
+		}
+	}
+	return nil, errors.New("folder not found")
+}
+
+func TestFolderResourceName(t *testing.T) {
+	tests := []struct {
+		uid  string
+		want string
+	}{
+		{uid: "f1", want: "f1"},
+		{uid: "ab-12", want: "ab-12"},
+		{uid: "nErOeDq4z", want: "neroedq4z-"},
+		{uid: "NEROEDQ4Z", want: "neroedq4z-"},
+		{uid: "a_b", want: "a-b-"},
+		{uid: "_", want: ""},
+	}
+	names := set.New[string]()
+	for _, tt := range tests {
+		t.Run(tt.uid, func(t *testing.T) {
+			name := folderResourceName(tt.uid)
+			assert.True(t, strings.HasPrefix(name, tt.want), name)
+			assert.Empty(t, validation.IsDNS1123Label(name))
+			assert.False(t, names.Contains(name), "duplicate name: %s", name)
+			names.Add(name)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"codeberg.org/clambin/go-common/set"
+	"sigs.k8s.io/yaml"
+)
+
+const kustomizationFile = "kustomization.yaml"
+
+// updateKustomization creates or updates the kustomization.yaml in the output directory, so the exported manifests
+// can be applied with "kubectl apply -k".
+//
+// Any resources already listed in the kustomization (e.g. by an earlier export of other kinds) are kept, unless
+// they were pruned. All other fields of an existing kustomization are left untouched, except for namespace and
+// commonLabels, which are set from the configuration.
+func (d *dirWriter) updateKustomization(pruned set.Set[string]) error {
+	target := filepath.Join(d.cfg.Dir, kustomizationFile)
+	kustomization := map[string]any{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+	}
+	body, err := os.ReadFile(target)
+	switch {
+	case err == nil:
+		if err = yaml.Unmarshal(body, &kustomization); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	resources := set.New[string]()
+	if current, ok := kustomization["resources"].([]any); ok {
+		for _, r := range current {
+			if resource, ok := r.(string); ok && !pruned.Contains(filepath.Join(d.cfg.Dir, filepath.FromSlash(resource))) {
+				resources.Add(resource)
+			}
+		}
+	}
+	for path := range d.written {
+		rel, err := filepath.Rel(d.cfg.Dir, path)
+		if err != nil {
+			return err
+		}
+		resources.Add(filepath.ToSlash(rel))
+	}
+	kustomization["resources"] = resources.ListOrdered()
+
+	if d.namespace != "" {
+		kustomization["namespace"] = d.namespace
+	}
+	if len(d.labels) > 0 {
+		commonLabels, _ := kustomization["commonLabels"].(map[string]any)
+		if commonLabels == nil {
+			commonLabels = make(map[string]any, len(d.labels))
+		}
+		for key, value := range d.labels {
+			commonLabels[key] = value
+		}
+		kustomization["commonLabels"] = commonLabels
+	}
+
+	if body, err = yaml.Marshal(kustomization); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(d.cfg.Dir, 0755); err != nil {
+		return err
+	}
+	d.logger.Debug("writing kustomization", "path", target)
+	return os.WriteFile(target, body, 0644)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/client/library_elements"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/grafana/grafana-operator/v5/api/v1beta1"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	libraryPanelsCmd = &cobra.Command{
+		Use:   "library-panels [flags] [name [...]]",
+		Short: "export Grafana library panels",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			return withManifestWriter(cfg, logger, []string{"GrafanaLibraryPanel"}, len(args) == 0, func(w manifestWriter) error {
+				return forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+					return exportLibraryPanels(w, client, cfg, set.New(args...), logger)
+				})
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(libraryPanelsCmd)
+}
+
+// libraryElementKindPanel is the kind of library element that holds a library panel.
+const libraryElementKindPanel = 1
+
+func exportLibraryPanels(
+	w manifestWriter,
+	client *grafanaClient,
+	cfg configuration,
+	args set.Set[string],
+	logger *slog.Logger,
+) error {
+	var skipped skippedItems
+	for panel, err := range grafanaLibraryPanels(client, args) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = writeLibraryPanel(w, cfg, panel); err != nil {
+			logger.Error("failed to write operator library panel", "err", err)
+			return err
+		}
+	}
+	return skipped.err()
+}
+
+// grafanaLibraryPanels returns all library panels whose name matches an element of args.
+// If args is empty, it returns all library panels.
+func grafanaLibraryPanels(c *grafanaClient, args set.Set[string]) iter.Seq2[*models.LibraryElementDTO, error] {
+	return func(yield func(*models.LibraryElementDTO, error) bool) {
+		params := library_elements.NewGetLibraryElementsParams()
+		params.Kind = constP(int64(libraryElementKindPanel))
+		var page int64
+		for page = 1; ; page++ {
+			params.Page = &page
+			ok, err := c.LibraryElements.GetLibraryElements(params)
+			if err != nil {
+				yield(nil, fmt.Errorf("list library panels: %w", err))
+				return
+			}
+			var elements []*models.LibraryElementDTO
+			if result := ok.GetPayload().Result; result != nil {
+				elements = result.Elements
+			}
+			if len(elements) == 0 {
+				return
+			}
+			for _, element := range elements {
+				if len(args) > 0 && !args.Contains(element.Name) {
+					continue
+				}
+				if !yield(element, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func writeLibraryPanel(w manifestWriter, cfg configuration, panel *models.LibraryElementDTO) error {
+	manifest, err := operatorLibraryPanel(cfg, panel)
+	if err != nil {
+		return fmt.Errorf("operator library panel: %w", err)
+	}
+	var folder string
+	if panel.Meta != nil {
+		folder = panel.Meta.FolderName
+	}
+	return w.WriteManifest(manifest.Kind, folder, manifest.Name, manifest)
+}
+
+// libraryPanelManifest is a GrafanaLibraryPanel custom resource without its Status section.
+type libraryPanelManifest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              v1beta1.GrafanaLibraryPanelSpec `json:"spec"`
+}
+
+func operatorLibraryPanel(cfg configuration, panel *models.LibraryElementDTO) (libraryPanelManifest, error) {
+	// the operator takes the library panel's UID and name from its model.
+	model, ok := panel.Model.(map[string]any)
+	if !ok {
+		return libraryPanelManifest{}, fmt.Errorf("unexpected model type: %T; expected map[string]any", panel.Model)
+	}
+	model["uid"] = panel.UID
+	model["name"] = panel.Name
+
+	var encodedPanel bytes.Buffer
+	jEnc := json.NewEncoder(&encodedPanel)
+	jEnc.SetIndent("", "  ")
+	if err := jEnc.Encode(model); err != nil {
+		return libraryPanelManifest{}, fmt.Errorf("json: %w", err)
+	}
+
+	manifest := libraryPanelManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       "GrafanaLibraryPanel",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      slug.Make(panel.Name),
+			Namespace: cfg.Namespace,
+		},
+		Spec: v1beta1.GrafanaLibraryPanelSpec{
+			GrafanaCommonSpec: cfg.commonSpec(),
+			GrafanaContentSpec: v1beta1.GrafanaContentSpec{
+				CustomUID: panel.UID,
+				JSON:      encodedPanel.String(),
+			},
+		},
+	}
+	// library panels in the General folder don't have a folder.
+	if panel.FolderUID != "" {
+		if cfg.FolderMode == folderModeRef {
+			manifest.Spec.FolderRef = folderResourceName(panel.FolderUID)
+		} else {
+			manifest.Spec.FolderUID = panel.FolderUID
+		}
+	}
+	return manifest, nil
+}
+
+// libraryPanelUIDs returns the UIDs of all library panels referenced by a dashboard model, including panels in
+// (collapsed) rows. Each UID is returned once, in order of appearance.
+func libraryPanelUIDs(dashboard any) []string {
+	var uids []string
+	seen := set.New[string]()
+	var walk func(panels any)
+	walk = func(panels any) {
+		panelList, _ := panels.([]any)
+		for _, p := range panelList {
+			panel, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			if libraryPanel, ok := panel["libraryPanel"].(map[string]any); ok {
+				if uid, ok := libraryPanel["uid"].(string); ok && uid != "" && !seen.Contains(uid) {
+					seen.Add(uid)
+					uids = append(uids, uid)
+				}
+			}
+			walk(panel["panels"])
+		}
+	}
+	if model, ok := dashboard.(map[string]any); ok {
+		walk(model["panels"])
+	}
+	return uids
+}
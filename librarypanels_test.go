@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/grafana/grafana-openapi-client-go/client/library_elements"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportLibraryPanels(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		export func(manifestWriter, *grafanaClient, configuration, set.Set[string], *slog.Logger) error
+	}{
+		{
+			name: "unfiltered",
+			export: func(w manifestWriter, c *grafanaClient, cfg configuration, args set.Set[string], l *slog.Logger) error {
+				return exportLibraryPanels(w, c, cfg, args, l)
+			},
+		},
+		{
+			name: "filtered",
+			args: []string{"panel 2"},
+			export: func(w manifestWriter, c *grafanaClient, cfg configuration, args set.Set[string], l *slog.Logger) error {
+				return exportLibraryPanels(w, c, cfg, args, l)
+			},
+		},
+		{
+			name: "with dashboards",
+			export: func(w manifestWriter, c *grafanaClient, cfg configuration, args set.Set[string], l *slog.Logger) error {
+				cfg.LibraryPanels = true
+				return exportDashboards(w, c, cfg, args, l)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			v := viper.New()
+			v.Set("grafana.url", "http://grafana")
+			cfg := configurationFromViper(v)
+			client := grafanaClient{
+				Search: fakeSearcher{hitList: models.HitList{
+					{Title: "db 1", Type: "dash-db", UID: "1"},
+					{Title: "db 2", Type: "dash-db", UID: "2"},
+				}},
+				Dashboards: fakeDashboardFetcher{dashboards: map[string]any{
+					"1": map[string]any{"panels": []any{
+						map[string]any{"id": 1, "libraryPanel": map[string]any{"uid": "p1", "name": "panel 1"}},
+					}},
+					"2": map[string]any{"panels": []any{
+						map[string]any{"id": 1, "libraryPanel": map[string]any{"uid": "p1", "name": "panel 1"}},
+						map[string]any{"id": 2, "type": "row", "panels": []any{
+							map[string]any{"id": 3, "libraryPanel": map[string]any{"uid": "p2", "name": "panel 2"}},
+						}},
+					}},
+				}},
+				LibraryElements: fakeLibraryElementsClient{elements: []*models.LibraryElementDTO{
+					{UID: "p1", Name: "panel 1", Kind: libraryElementKindPanel, Model: map[string]any{"type": "timeseries"}},
+					{UID: "p2", Name: "panel 2", Kind: libraryElementKindPanel, FolderUID: "f1", Model: map[string]any{"type": "stat"}},
+				}},
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, tt.export(&streamWriter{w: &buf}, &client, cfg, set.New(tt.args...), logger))
+
+			gp := filepath.Join("testdata", slug.Make(t.Name())+".yaml")
+			if *update {
+				require.NoError(t, os.WriteFile(gp, buf.Bytes(), 0644))
+			}
+			golden, err := os.ReadFile(gp)
+			require.NoError(t, err)
+			assert.Equal(t, string(golden), buf.String())
+		})
+	}
+}
+
+func TestExportLibraryPanels_Error(t *testing.T) {
+	client := grafanaClient{LibraryElements: fakeLibraryElementsClient{err: errors.New("server error")}}
+	err := exportLibraryPanels(&streamWriter{w: &bytes.Buffer{}}, &client, configuration{}, set.New[string](), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.Equal(t, "list library panels: server error", err.Error())
+}
+
+func Test_libraryPanelUIDs(t *testing.T) {
+	dashboard := map[string]any{"panels": []any{
+		map[string]any{"id": 1, "libraryPanel": map[string]any{"uid": "p1"}},
+		map[string]any{"id": 2, "type": "row", "panels": []any{
+			map[string]any{"id": 3, "libraryPanel": map[string]any{"uid": "p2"}},
+			map[string]any{"id": 4, "libraryPanel": map[string]any{"uid": "p1"}},
+		}},
+		map[string]any{"id": 5, "type": "timeseries"},
+	}}
+	assert.Equal(t, []string{"p1", "p2"}, libraryPanelUIDs(dashboard))
+	assert.Empty(t, libraryPanelUIDs("invalid"))
+}
+
+var _ grafanaLibraryElementsClient = fakeLibraryElementsClient{}
+
+type fakeLibraryElementsClient struct {
+	elements []*models.LibraryElementDTO
+	err      error
+}
+
+func (f fakeLibraryElementsClient) GetLibraryElements(params *library_elements.GetLibraryElementsParams, _ ...library_elements.ClientOption) (*library_elements.GetLibraryElementsOK, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := library_elements.NewGetLibraryElementsOK()
+	result.Payload = &models.LibraryElementSearchResponse{Result: &models.LibraryElementSearchResult{}}
+	// fake only supports a single page
+	if params.Page == nil || *params.Page == 1 {
+		result.Payload.Result.Elements = f.elements
+	}
+	return result, nil
+}
+
+func (f fakeLibraryElementsClient) GetLibraryElementByUID(uid string, _ ...library_elements.ClientOption) (*library_elements.GetLibraryElementByUIDOK, error) {
+	for _, element := range f.elements {
+		if element.UID == uid {
+			result := library_elements.NewGetLibraryElementByUIDOK()
+			result.Payload = &models.LibraryElementResponse{Result: element}
+			return result, nil
+		}
+	}
+	return nil, errors.New("library element not found")
+}
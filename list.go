@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"codeberg.org/clambin/go-common/set"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list the Grafana resources that grope would export",
+	}
+	listDashboardsCmd = &cobra.Command{
+		Use:   "dashboards [flags] [name [...]]",
+		Short: "list Grafana dashboards",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cmd, dashboardColumns, func(cfg configuration, client *grafanaClient, logger *slog.Logger) ([]inventoryItem, error) {
+				return listDashboards(client, cfg, set.New(args...), logger)
+			})
+		},
+	}
+	listDataSourcesCmd = &cobra.Command{
+		Use:   "datasources [flags] [name [...]]",
+		Short: "list Grafana data sources",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cmd, datasourceColumns, func(cfg configuration, client *grafanaClient, logger *slog.Logger) ([]inventoryItem, error) {
+				return listDatasources(client, cfg, args, logger)
+			})
+		},
+	}
+	listFoldersCmd = &cobra.Command{
+		Use:   "folders [flags] [title [...]]",
+		Short: "list Grafana folders",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cmd, folderColumns, func(cfg configuration, client *grafanaClient, logger *slog.Logger) ([]inventoryItem, error) {
+				return listFolders(client, cfg, set.New(args...), logger)
+			})
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.PersistentFlags().String("format", listFormatTable, "Output format: table or json")
+	_ = viper.BindPFlag("list.format", listCmd.PersistentFlags().Lookup("format"))
+	listCmd.AddCommand(listDashboardsCmd, listDataSourcesCmd, listFoldersCmd)
+	listDashboardsCmd.Flags().AddFlagSet(dashboardFilterFlags)
+	listDataSourcesCmd.Flags().AddFlagSet(datasourceFilterFlags)
+}
+
+const (
+	listFormatTable = "table"
+	listFormatJSON  = "json"
+)
+
+// inventoryItem is a Grafana resource, as listed by the list command.
+type inventoryItem struct {
+	Org     int64     `json:"org,omitempty"`
+	Title   string    `json:"title"`
+	UID     string    `json:"uid"`
+	Type    string    `json:"type,omitempty"`
+	Folder  string    `json:"folder,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Version int64     `json:"version,omitempty"`
+	Updated time.Time `json:"updated,omitzero"`
+}
+
+// inventoryColumn is a column of the table printed by the list command.
+type inventoryColumn struct {
+	header string
+	value  func(inventoryItem) string
+}
+
+var (
+	titleColumn   = inventoryColumn{header: "TITLE", value: func(i inventoryItem) string { return i.Title }}
+	uidColumn     = inventoryColumn{header: "UID", value: func(i inventoryItem) string { return i.UID }}
+	versionColumn = inventoryColumn{header: "VERSION", value: func(i inventoryItem) string { return strconv.FormatInt(i.Version, 10) }}
+
+	dashboardColumns = []inventoryColumn{
+		titleColumn,
+		uidColumn,
+		{header: "FOLDER", value: func(i inventoryItem) string { return i.Folder }},
+		{header: "TAGS", value: func(i inventoryItem) string { return strings.Join(i.Tags, ",") }},
+		versionColumn,
+		{header: "UPDATED", value: func(i inventoryItem) string {
+			if i.Updated.IsZero() {
+				return "-"
+			}
+			return i.Updated.UTC().Format(time.RFC3339)
+		}},
+	}
+	datasourceColumns = []inventoryColumn{
+		{header: "NAME", value: func(i inventoryItem) string { return i.Title }},
+		uidColumn,
+		{header: "TYPE", value: func(i inventoryItem) string { return i.Type }},
+		versionColumn,
+	}
+	folderColumns = []inventoryColumn{
+		titleColumn,
+		uidColumn,
+		{header: "PARENT", value: func(i inventoryItem) string { return i.Folder }},
+	}
+	orgColumn = inventoryColumn{header: "ORG", value: func(i inventoryItem) string { return strconv.FormatInt(i.Org, 10) }}
+)
+
+// runList lists the resources of each organisation and prints them to the command's output.
+// If resources were skipped, it prints the resources it could retrieve, before returning the error.
+func runList(cmd *cobra.Command, columns []inventoryColumn, list func(configuration, *grafanaClient, *slog.Logger) ([]inventoryItem, error)) error {
+	cfg := configurationFromViper(viper.GetViper())
+	logger := charmer.GetLogger(cmd)
+	var items []inventoryItem
+	err := forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+		orgItems, err := list(cfg, client, logger)
+		items = append(items, orgItems...)
+		return err
+	})
+	if err != nil && !cfg.ContinueOnError {
+		return err
+	}
+	if cfg.AllOrgs {
+		columns = append([]inventoryColumn{orgColumn}, columns...)
+	}
+	if err2 := writeInventory(cmd.OutOrStdout(), viper.GetString("list.format"), columns, items); err2 != nil {
+		return err2
+	}
+	return err
+}
+
+// listDashboards returns all dashboards that grafanaDashboards selects for export.
+func listDashboards(client *grafanaClient, cfg configuration, args set.Set[string], logger *slog.Logger) ([]inventoryItem, error) {
+	var skipped skippedItems
+	var items []inventoryItem
+	for db, err := range grafanaDashboards(client, cfg.Folders, args, cfg.DashboardFilter, cfg.Concurrency) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return items, err
+			}
+			continue
+		}
+		item := inventoryItem{
+			Org:    cfg.Org,
+			Title:  db.entry.Title,
+			UID:    db.entry.UID,
+			Folder: db.entry.FolderTitle,
+			Tags:   db.entry.Tags,
+		}
+		if meta := db.dashboard.Meta; meta != nil {
+			item.Version = meta.Version
+			item.Updated = time.Time(meta.Updated)
+		}
+		items = append(items, item)
+	}
+	return items, skipped.err()
+}
+
+// listDatasources returns all datasources that grafanaDataSources selects for export.
+func listDatasources(client *grafanaClient, cfg configuration, args []string, logger *slog.Logger) ([]inventoryItem, error) {
+	var skipped skippedItems
+	var items []inventoryItem
+	for datasource, err := range grafanaDataSources(client, args, cfg.DatasourceFilter) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return items, err
+			}
+			continue
+		}
+		items = append(items, inventoryItem{
+			Org:     cfg.Org,
+			Title:   datasource.Name,
+			UID:     datasource.UID,
+			Type:    datasource.Type,
+			Version: datasource.Version,
+		})
+	}
+	return items, skipped.err()
+}
+
+// listFolders returns all folders whose title matches an element of args (or all folders if args is empty).
+// Subfolders list the title of their parent folder.
+func listFolders(client *grafanaClient, cfg configuration, args set.Set[string], logger *slog.Logger) ([]inventoryItem, error) {
+	var skipped skippedItems
+	var items []inventoryItem
+	for folder, err := range grafanaFolders(client) {
+		if err != nil {
+			if err = skipped.skip(cfg, logger, err); err != nil {
+				return items, err
+			}
+			continue
+		}
+		if len(args) > 0 && !args.Contains(folder.folder.Title) {
+			continue
+		}
+		items = append(items, inventoryItem{
+			Org:    cfg.Org,
+			Title:  folder.folder.Title,
+			UID:    folder.folder.UID,
+			Folder: parentTitle(folder.parent),
+		})
+	}
+	return items, skipped.err()
+}
+
+func parentTitle(parent *models.FolderSearchHit) string {
+	if parent == nil {
+		return ""
+	}
+	return parent.Title
+}
+
+// writeInventory prints the items as a table with the given columns, or as a JSON array.
+func writeInventory(w io.Writer, format string, columns []inventoryColumn, items []inventoryItem) error {
+	switch format {
+	case listFormatJSON:
+		if items == nil {
+			items = []inventoryItem{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	case listFormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = column.header
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+		for _, item := range items {
+			for i, column := range columns {
+				row[i] = column.value(item)
+			}
+			_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("invalid list format %q", format)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListDashboards(t *testing.T) {
+	v := viper.New()
+	v.Set("grafana.url", "http://grafana")
+	v.Set("dashboards.exclude.tag", []string{"wip"})
+	cfg := configurationFromViper(v)
+	client := grafanaClient{
+		Search: fakeSearcher{hitList: models.HitList{
+			{Title: "db 1", FolderTitle: "folder 1", FolderUID: "f1", Type: "dash-db", UID: "1", Tags: []string{"a", "b"}},
+			{Title: "db 2", Type: "dash-db", UID: "2"},
+			{Title: "db 3", Type: "dash-db", UID: "3", Tags: []string{"wip"}},
+		}},
+		Dashboards: fakeDashboardFetcher{dashboards: map[string]any{
+			"1": map[string]any{"title": "db 1"},
+			"2": map[string]any{"title": "db 2"},
+			"3": map[string]any{"title": "db 3"},
+		}},
+	}
+
+	items, err := listDashboards(&client, cfg, set.New[string](), slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Equal(t, []inventoryItem{
+		{Title: "db 1", UID: "1", Folder: "folder 1", Tags: []string{"a", "b"}},
+		{Title: "db 2", UID: "2"},
+	}, items)
+}
+
+func TestListDatasources(t *testing.T) {
+	v := viper.New()
+	v.Set("grafana.url", "http://grafana")
+	v.Set("datasources.include.type", []string{"prometheus"})
+	cfg := configurationFromViper(v)
+	client := grafanaClient{
+		Datasources: fakeDataSourceFetcher{dataSources: map[string]*models.DataSource{
+			"prometheus": {Name: "prometheus", UID: "prom-1", Type: "prometheus", Version: 3},
+			"loki":       {Name: "loki", UID: "loki-1", Type: "loki"},
+		}},
+	}
+
+	items, err := listDatasources(&client, cfg, nil, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Equal(t, []inventoryItem{{Title: "prometheus", UID: "prom-1", Type: "prometheus", Version: 3}}, items)
+}
+
+func TestListFolders(t *testing.T) {
+	client := grafanaClient{
+		Folders: fakeFolderFetcher{folders: map[string][]*models.FolderSearchHit{
+			"":   {{Title: "folder 1", UID: "f1"}, {Title: "folder 2", UID: "f2"}},
+			"f1": {{Title: "folder 1.1", UID: "f11", ParentUID: "f1"}},
+		}},
+	}
+
+	items, err := listFolders(&client, configuration{}, set.New("folder 1", "folder 1.1"), slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Equal(t, []inventoryItem{
+		{Title: "folder 1", UID: "f1"},
+		{Title: "folder 1.1", UID: "f11", Folder: "folder 1"},
+	}, items)
+}
+
+func TestWriteInventory(t *testing.T) {
+	items := []inventoryItem{
+		{Title: "db 1", UID: "1", Folder: "folder 1", Tags: []string{"a", "b"}, Version: 4, Updated: time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)},
+		{Title: "db 2", UID: "2"},
+	}
+	tests := []struct {
+		name    string
+		format  string
+		items   []inventoryItem
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:   "table",
+			format: listFormatTable,
+			items:  items,
+			want: `TITLE  UID  FOLDER    TAGS  VERSION  UPDATED
+db 1   1    folder 1  a,b   4        2025-03-01T12:00:00Z
+db 2   2                    0        -
+`,
+			wantErr: assert.NoError,
+		},
+		{
+			name:   "json",
+			format: listFormatJSON,
+			items:  items,
+			want: `[
+  {
+    "title": "db 1",
+    "uid": "1",
+    "folder": "folder 1",
+    "tags": [
+      "a",
+      "b"
+    ],
+    "version": 4,
+    "updated": "2025-03-01T12:00:00Z"
+  },
+  {
+    "title": "db 2",
+    "uid": "2"
+  }
+]
+`,
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "empty json",
+			format:  listFormatJSON,
+			want:    "[]\n",
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "invalid format",
+			format:  "yaml",
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeInventory(&buf, tt.format, dashboardColumns, tt.items)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
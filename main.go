@@ -34,13 +34,23 @@ func init() {
 }
 
 var args = charmer.Arguments{
-	"debug":                        {Default: false, Help: "Log debug messages"},
-	"namespace":                    {Default: "", Help: "Namespace for k8s config maps (default: no namespace added)"},
-	"tags":                         {Default: "", Help: "Dashboard tags (comma-separated; optional)"},
-	"grafana.url":                  {Default: "http://localhost:3000", Help: "Grafana URL"},
-	"grafana.token":                {Default: "", Help: "Grafana API token (must have admin rights)"},
-	"grafana.operator.label.name":  {Default: "dashboards", Help: "label used to select the grafana instance"},
-	"grafana.operator.label.value": {Default: "grafana", Help: "label value used to select the grafana instance"},
+	"debug":                          {Default: false, Help: "Log debug messages"},
+	"namespace":                      {Default: "", Help: "Namespace for k8s config maps (default: no namespace added)"},
+	"tags":                           {Default: "", Help: "Dashboard tags (comma-separated; optional)"},
+	"folder-mode":                    {Default: folderModeTitle, Help: "How resources refer to their folder: title, ref (GrafanaFolder resource) or uid"},
+	"grafana.url":                    {Default: "http://localhost:3000", Help: "Grafana URL"},
+	"grafana.basePath":               {Default: "", Help: "Path under which Grafana is served (default: the path of grafana.url)"},
+	"grafana.token":                  {Default: "", Help: "Grafana API token (must have admin rights)"},
+	"grafana.tokenFile":              {Default: "", Help: "File holding the Grafana API token"},
+	"grafana.username":               {Default: "", Help: "Grafana user name (basic auth)"},
+	"grafana.password":               {Default: "", Help: "Grafana password (basic auth)"},
+	"grafana.tls.ca":                 {Default: "", Help: "CA certificate(s) to verify Grafana's certificate (PEM file)"},
+	"grafana.tls.cert":               {Default: "", Help: "Client certificate to authenticate to Grafana (PEM file)"},
+	"grafana.tls.key":                {Default: "", Help: "Client certificate's private key (PEM file)"},
+	"grafana.tls.serverName":         {Default: "", Help: "Server name to verify Grafana's certificate against (default: the host of grafana.url)"},
+	"grafana.tls.insecureSkipVerify": {Default: false, Help: "Don't verify Grafana's certificate"},
+	"grafana.operator.label.name":    {Default: "dashboards", Help: "label used to select the grafana instance"},
+	"grafana.operator.label.value":   {Default: "grafana", Help: "label value used to select the grafana instance"},
 }
 
 func initArgs() {
@@ -49,11 +59,17 @@ func initArgs() {
 	}
 
 	rootCmd.PersistentFlags().StringVarP(&configFilename, "config", "c", "", "Configuration file")
+	rootCmd.PersistentFlags().String("profile", "", "Configuration profile to apply (profiles.<name> in the configuration file)")
+	_ = viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 	_ = charmer.SetPersistentFlags(&rootCmd, viper.GetViper(), args)
 }
 
 func initConfig() {
 	initViper(viper.GetViper())
+	if err := applyProfile(viper.GetViper()); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid profile: %s\n", err.Error())
+		os.Exit(1)
+	}
 }
 
 func initViper(v *viper.Viper) {
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Bool("normalize", false, "Remove volatile fields from the dashboards, so re-exporting an unchanged dashboard doesn't change its manifest")
+	_ = viper.BindPFlag("normalize.enabled", rootCmd.PersistentFlags().Lookup("normalize"))
+	rootCmd.PersistentFlags().StringSlice("normalize-fields", nil, "Volatile fields to remove: "+fmt.Sprint(slices.Sorted(maps.Keys(volatileDashboardFields)))+" (default: all)")
+	_ = viper.BindPFlag("normalize.fields", rootCmd.PersistentFlags().Lookup("normalize-fields"))
+}
+
+type normalizeConfiguration struct {
+	Enabled bool
+	Fields  []string
+}
+
+// volatileDashboardFields remove fields from a dashboard model that change without the dashboard itself changing:
+//   - id: the dashboard's database ID, which differs between Grafana instances
+//   - version: incremented each time the dashboard is saved
+//   - iteration: a timestamp of when the dashboard was last loaded in the UI
+//   - current: the selected value of each template variable
+var volatileDashboardFields = map[string]func(model map[string]any){
+	"id":        func(model map[string]any) { delete(model, "id") },
+	"version":   func(model map[string]any) { delete(model, "version") },
+	"iteration": func(model map[string]any) { delete(model, "iteration") },
+	"current": func(model map[string]any) {
+		templating, _ := model["templating"].(map[string]any)
+		list, _ := templating["list"].([]any)
+		for _, v := range list {
+			if variable, ok := v.(map[string]any); ok {
+				delete(variable, "current")
+			}
+		}
+	},
+}
+
+// normalizeDashboard removes the configured volatile fields from the dashboard's model (all fields if none are
+// configured). As the model is encoded with its keys sorted, exporting an unchanged dashboard then always gives
+// the same JSON.
+func normalizeDashboard(db *models.DashboardFullWithMeta, fields ...string) error {
+	model, ok := db.Dashboard.(map[string]any)
+	if !ok {
+		return fmt.Errorf("unexpected model type: %T; expected map[string]any", db.Dashboard)
+	}
+	if len(fields) == 0 {
+		fields = slices.Sorted(maps.Keys(volatileDashboardFields))
+	}
+	for _, field := range fields {
+		normalize, ok := volatileDashboardFields[field]
+		if !ok {
+			return fmt.Errorf("invalid field %q", field)
+		}
+		normalize(model)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVolatileDashboard(id, version int, iteration int64, current string) map[string]any {
+	return map[string]any{
+		"id":        id,
+		"uid":       "db-1",
+		"title":     "db 1",
+		"version":   version,
+		"iteration": iteration,
+		"templating": map[string]any{"list": []any{
+			map[string]any{"name": "job", "current": map[string]any{"text": current, "value": current}},
+		}},
+	}
+}
+
+func TestNormalizeDashboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		want    map[string]any
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name: "all fields",
+			want: map[string]any{
+				"uid":        "db-1",
+				"title":      "db 1",
+				"templating": map[string]any{"list": []any{map[string]any{"name": "job"}}},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:   "selected fields",
+			fields: []string{"id", "iteration"},
+			want: map[string]any{
+				"uid":     "db-1",
+				"title":   "db 1",
+				"version": 3,
+				"templating": map[string]any{"list": []any{
+					map[string]any{"name": "job", "current": map[string]any{"text": "foo", "value": "foo"}},
+				}},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "invalid field",
+			fields:  []string{"title"},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := models.DashboardFullWithMeta{Dashboard: testVolatileDashboard(10, 3, 1700000000, "foo")}
+			err := normalizeDashboard(&db, tt.fields...)
+			tt.wantErr(t, err)
+			if err == nil {
+				assert.Equal(t, tt.want, db.Dashboard)
+			}
+		})
+	}
+}
+
+func TestNormalizeDashboard_InvalidModel(t *testing.T) {
+	assert.Error(t, normalizeDashboard(&models.DashboardFullWithMeta{Dashboard: "foo"}))
+}
+
+func TestOperatorDashboard_Normalized(t *testing.T) {
+	v := viper.New()
+	v.Set("normalize.enabled", true)
+	cfg := configurationFromViper(v)
+	entry := models.Hit{Title: "db 1", UID: "db-1"}
+
+	db1, _, err := operatorDashboard(cfg, &entry, &models.DashboardFullWithMeta{Dashboard: testVolatileDashboard(10, 3, 1700000000, "foo")}, nil)
+	require.NoError(t, err)
+	db2, _, err := operatorDashboard(cfg, &entry, &models.DashboardFullWithMeta{Dashboard: testVolatileDashboard(11, 4, 1800000000, "bar")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, db1.Spec.JSON, db2.Spec.JSON)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-openapi-client-go/client/orgs"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Int64("org", 0, "ID of the Grafana organisation to export (default: the organisation of the credentials)")
+	_ = viper.BindPFlag("org", rootCmd.PersistentFlags().Lookup("org"))
+	rootCmd.PersistentFlags().Bool("all-orgs", false, "Export all Grafana organisations (requires server admin rights)")
+	_ = viper.BindPFlag("all-orgs", rootCmd.PersistentFlags().Lookup("all-orgs"))
+}
+
+// orgConfiguration overrides the namespace and instance selector of the resources exported from an organisation.
+// In the configuration file, orgs are keyed by their ID or (lowercase) name:
+//
+//	orgs:
+//	  "2":
+//	    namespace: team-a
+//	    labels:
+//	      dashboards: grafana-team-a
+type orgConfiguration struct {
+	Namespace string
+	Labels    map[string]string
+}
+
+func orgsFromViper(v *viper.Viper) map[string]orgConfiguration {
+	var orgConfigs map[string]orgConfiguration
+	_ = v.UnmarshalKey("orgs", &orgConfigs)
+	return orgConfigs
+}
+
+// forOrg returns the configuration to export an organisation.
+func (c configuration) forOrg(id int64, name string) configuration {
+	c.Org = id
+	orgConfig, ok := c.Orgs[strconv.FormatInt(id, 10)]
+	if !ok && name != "" {
+		orgConfig, ok = c.Orgs[strings.ToLower(name)]
+	}
+	if !ok {
+		return c
+	}
+	if orgConfig.Namespace != "" {
+		c.Namespace = orgConfig.Namespace
+	}
+	if orgConfig.Labels != nil {
+		c.Grafana.Operator.Labels = orgConfig.Labels
+	}
+	return c
+}
+
+// forEachOrg runs export with the configuration and client for the configured organisation. If all organisations
+// are to be exported, it runs export for each organisation in Grafana.
+func forEachOrg(cfg configuration, logger *slog.Logger, export func(configuration, *grafanaClient) error) error {
+	if !cfg.AllOrgs {
+		cfg = cfg.forOrg(cfg.Org, "")
+		client, err := cfg.grafanaClient()
+		if err != nil {
+			return fmt.Errorf("grafana: %w", err)
+		}
+		return export(cfg, client)
+	}
+
+	client, err := cfg.grafanaClient()
+	if err != nil {
+		return fmt.Errorf("grafana: %w", err)
+	}
+	organisations, err := grafanaOrgs(client)
+	if err != nil {
+		return fmt.Errorf("orgs: %w", err)
+	}
+	// if the export continues on errors, an organisation with skipped items doesn't stop the other organisations
+	// from being exported. The errors are returned once all organisations are done.
+	var errs []error
+	for _, org := range organisations {
+		logger.Debug("exporting organisation", "org", org.Name, "id", org.ID)
+		orgCfg := cfg.forOrg(org.ID, org.Name)
+		orgClient, err := orgCfg.grafanaClient()
+		if err != nil {
+			return fmt.Errorf("grafana: %w", err)
+		}
+		if err = export(orgCfg, orgClient); err != nil {
+			err = fmt.Errorf("org %q: %w", org.Name, err)
+			if !cfg.ContinueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// grafanaOrgs returns all organisations in Grafana.
+func grafanaOrgs(c *grafanaClient) ([]*models.OrgDTO, error) {
+	var organisations []*models.OrgDTO
+	params := orgs.NewSearchOrgsParams()
+	var page int64
+	for page = 1; ; page++ {
+		params.Page = &page
+		ok, err := c.Orgs.SearchOrgs(params)
+		if err != nil {
+			return nil, err
+		}
+		payload := ok.GetPayload()
+		if len(payload) == 0 {
+			return organisations, nil
+		}
+		organisations = append(organisations, payload...)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-openapi-client-go/client/search"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachOrg(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/orgs":
+			if r.URL.Query().Get("page") == "1" {
+				_, _ = w.Write([]byte(`[{"id":1,"name":"Main Org."},{"id":2,"name":"Team A"},{"id":3,"name":"Team B"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		case "/api/search":
+			_, _ = w.Write([]byte(`[{"title":"db ` + r.Header.Get("X-Grafana-Org-Id") + `","type":"dash-db"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	tests := []struct {
+		name string
+		org  int64
+		all  bool
+		want []string
+	}{
+		{name: "default org", want: []string{"default/dashboards=grafana/db "}},
+		{name: "single org", org: 2, want: []string{"team-a/dashboards=grafana-team-a/db 2"}},
+		{name: "all orgs", all: true, want: []string{
+			"default/dashboards=grafana/db 1",
+			"team-a/dashboards=grafana-team-a/db 2",
+			"team-b/dashboards=grafana/db 3",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			require.NoError(t, v.ReadConfig(bytes.NewBufferString(`
+namespace: default
+grafana:
+  username: admin
+  password: admin
+orgs:
+  "2":
+    namespace: team-a
+    labels:
+      dashboards: grafana-team-a
+  team b:
+    namespace: team-b
+`)))
+			v.Set("grafana.url", s.URL)
+			v.Set("org", tt.org)
+			v.Set("all-orgs", tt.all)
+
+			var got []string
+			err := forEachOrg(configurationFromViper(v), slog.New(slog.DiscardHandler), func(cfg configuration, client *grafanaClient) error {
+				hits, err := client.Search.Search(search.NewSearchParams())
+				if err != nil {
+					return err
+				}
+				for _, hit := range hits.GetPayload() {
+					got = append(got, cfg.Namespace+"/dashboards="+cfg.Grafana.Operator.Labels["dashboards"]+"/"+hit.Title)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestForEachOrg_ContinueOnError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/orgs":
+			if r.URL.Query().Get("page") == "1" {
+				_, _ = w.Write([]byte(`[{"id":1,"name":"Main Org."},{"id":2,"name":"Team A"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		case "/api/datasources/name/loki":
+			// the first organisation has no loki datasource
+			if r.Header.Get("X-Grafana-Org-Id") == "1" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"message":"not found"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"loki","type":"loki","uid":"loki-` + r.Header.Get("X-Grafana-Org-Id") + `"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	v := viper.New()
+	v.Set("grafana.url", s.URL)
+	v.Set("grafana.username", "admin")
+	v.Set("grafana.password", "admin")
+	v.Set("all-orgs", true)
+	v.Set("continue-on-error", true)
+	cfg := configurationFromViper(v)
+	logger := slog.New(slog.DiscardHandler)
+
+	var buf bytes.Buffer
+	err := forEachOrg(cfg, logger, func(cfg configuration, client *grafanaClient) error {
+		return exportDatasources(&streamWriter{w: &buf}, client, cfg, []string{"loki"}, logger)
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `org "Main Org.": 1 item(s) skipped`)
+	assert.Contains(t, buf.String(), "uid: loki-2")
+}
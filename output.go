@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/gosimple/slug"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringP("output-dir", "o", "", "Write each manifest to its own file in this directory (default: write all manifests to stdout)")
+	_ = viper.BindPFlag("output.dir", rootCmd.PersistentFlags().Lookup("output-dir"))
+	rootCmd.PersistentFlags().String("output-layout", defaultOutputLayout, "Path of each manifest in the output directory. Supports {kind}, {namespace}, {folder} and {name}")
+	_ = viper.BindPFlag("output.layout", rootCmd.PersistentFlags().Lookup("output-layout"))
+	rootCmd.PersistentFlags().String("output-existing", existingOverwrite, "What to do with existing files in the output directory: overwrite or skip")
+	_ = viper.BindPFlag("output.existing", rootCmd.PersistentFlags().Lookup("output-existing"))
+	rootCmd.PersistentFlags().Bool("output-prune", false, "Remove manifests from the output directory that are no longer present in Grafana. Only applies to exports without names or filters")
+	_ = viper.BindPFlag("output.prune", rootCmd.PersistentFlags().Lookup("output-prune"))
+}
+
+const (
+	defaultOutputLayout = "{kind}/{folder}/{name}.yaml"
+
+	existingOverwrite = "overwrite"
+	existingSkip      = "skip"
+)
+
+// manifestWriter writes the manifests created by an export.
+//
+// kind and name are the kind and name of the custom resource. folder is the title of the Grafana folder
+// holding the exported object, or blank if the object has no folder.
+type manifestWriter interface {
+	WriteManifest(kind, folder, name string, manifest any) error
+	Close() error
+}
+
+// newManifestWriter returns the manifestWriter for the output configuration. If no output directory is configured,
+// it writes all manifests to w, as a multi-document YAML stream.
+//
+// kinds are the kinds of custom resources created by the export. When pruning, only stale files of these kinds are removed.
+func newManifestWriter(c configuration, w io.Writer, logger *slog.Logger, kinds ...string) (manifestWriter, error) {
+	cfg := c.Output
+	if cfg.Dir == "" {
+		return &streamWriter{w: w}, nil
+	}
+	if cfg.Layout == "" {
+		cfg.Layout = defaultOutputLayout
+	}
+	if !strings.Contains(cfg.Layout, "{name}") {
+		return nil, fmt.Errorf("invalid output layout %q: must contain {name}", cfg.Layout)
+	}
+	switch cfg.Existing {
+	case "":
+		cfg.Existing = existingOverwrite
+	case existingOverwrite, existingSkip:
+	default:
+		return nil, fmt.Errorf("invalid output existing policy %q", cfg.Existing)
+	}
+	d := dirWriter{
+		cfg:       cfg,
+		namespace: c.Namespace,
+		labels:    c.Grafana.Operator.Labels,
+		kinds:     set.New(kinds...),
+		written:   set.New[string](),
+		logger:    logger,
+	}
+	// organisations may be mapped to different namespaces and instance selectors, so the manifests don't necessarily
+	// share a namespace and labels.
+	if c.AllOrgs && len(c.Orgs) > 0 {
+		d.namespace, d.labels = "", nil
+	}
+	return &d, nil
+}
+
+var _ manifestWriter = &streamWriter{}
+
+// streamWriter writes manifests as a multi-document YAML stream.
+type streamWriter struct {
+	w io.Writer
+}
+
+func (s *streamWriter) WriteManifest(_, _, _ string, manifest any) error {
+	body, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, _ = s.w.Write([]byte("---\n"))
+	_, err = s.w.Write(body)
+	return err
+}
+
+func (s *streamWriter) Close() error {
+	return nil
+}
+
+var _ manifestWriter = &dirWriter{}
+
+// dirWriter writes each manifest to its own file in a directory.
+type dirWriter struct {
+	cfg       outputConfiguration
+	namespace string
+	labels    map[string]string
+	kinds     set.Set[string]
+	written   set.Set[string]
+	logger    *slog.Logger
+}
+
+func (d *dirWriter) WriteManifest(kind, folder, name string, manifest any) error {
+	body, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	namespace := d.namespace
+	var objectMeta struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err = yaml.Unmarshal(body, &objectMeta); err == nil && objectMeta.Metadata.Namespace != "" {
+		namespace = objectMeta.Metadata.Namespace
+	}
+
+	target := d.path(kind, namespace, folder, name)
+	if d.written.Contains(target) {
+		d.logger.Warn("duplicate manifest. overwriting previous one", "path", target)
+	}
+	d.written.Add(target)
+
+	if d.cfg.Existing == existingSkip {
+		if _, err := os.Stat(target); err == nil {
+			d.logger.Debug("manifest exists. skipping", "path", target)
+			return nil
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	d.logger.Debug("writing manifest", "path", target)
+	return os.WriteFile(target, body, 0644)
+}
+
+// path returns the file name of a manifest, based on the configured layout.
+func (d *dirWriter) path(kind, namespace, folder, name string) string {
+	p := strings.NewReplacer(
+		"{kind}", strings.ToLower(kind),
+		"{namespace}", namespace,
+		"{folder}", slug.Make(folder),
+		"{name}", name,
+	).Replace(d.cfg.Layout)
+	return filepath.Join(d.cfg.Dir, filepath.Clean(p))
+}
+
+// Close removes stale manifests, if pruning is enabled, and updates the kustomization.yaml in the output directory.
+func (d *dirWriter) Close() error {
+	pruned := set.New[string]()
+	if d.cfg.Prune {
+		var err error
+		if pruned, err = d.prune(); err != nil {
+			return fmt.Errorf("prune: %w", err)
+		}
+	}
+	if err := d.updateKustomization(pruned); err != nil {
+		return fmt.Errorf("kustomization: %w", err)
+	}
+	return nil
+}
+
+// prune removes all manifests of the export's kinds that weren't written by the export. It returns the removed files.
+func (d *dirWriter) prune() (set.Set[string], error) {
+	var stale []string
+	err := filepath.WalkDir(d.cfg.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".yaml" || d.written.Contains(path) {
+			return err
+		}
+		if kind, err := manifestKind(path); err == nil && d.kinds.Contains(kind) {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stale {
+		d.logger.Info("removing stale manifest", "path", path)
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+		// remove any directories that are now empty. os.Remove fails for directories that aren't.
+		dir := filepath.Dir(path)
+		for dir != filepath.Clean(d.cfg.Dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+	return set.New(stale...), nil
+}
+
+// manifestKind returns the kind of the custom resource in a manifest file.
+func manifestKind(path string) (string, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var typeMeta metav1.TypeMeta
+	if err = yaml.Unmarshal(body, &typeMeta); err != nil {
+		return "", err
+	}
+	if typeMeta.Kind == "" {
+		return "", errors.New("no kind found")
+	}
+	return typeMeta.Kind, nil
+}
+
+// readManifests calls f for each YAML document in a manifest file or, if path is a directory, in each .yaml or .yml
+// file in that directory (and its subdirectories). Blank documents are skipped.
+func readManifests(path string, f func(file string, document []byte) error) error {
+	return filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		// files passed explicitly are read regardless of their extension.
+		if ext := filepath.Ext(file); ext != ".yaml" && ext != ".yml" && file != path {
+			return nil
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, document := range splitYAMLDocuments(body) {
+			if err = f(file, document); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+		return nil
+	})
+}
+
+// splitYAMLDocuments splits a multi-document YAML stream into its (non-blank) documents.
+func splitYAMLDocuments(body []byte) [][]byte {
+	var documents [][]byte
+	var current strings.Builder
+	flush := func() {
+		if strings.TrimSpace(current.String()) != "" {
+			documents = append(documents, []byte(current.String()))
+		}
+		current.Reset()
+	}
+	for line := range strings.Lines(string(body)) {
+		if strings.TrimRight(line, " \t\r\n") == "---" {
+			flush()
+			continue
+		}
+		current.WriteString(line)
+	}
+	flush()
+	return documents
+}
+
+// withManifestWriter runs an export with the manifestWriter for the configuration. Manifests are written to stdout,
+// unless an output directory is configured.
+//
+// Stale manifests are only pruned if the export succeeded and is complete, i.e. it covers all resources of its kinds.
+// Otherwise, the manifests of the resources that weren't selected would be removed.
+func withManifestWriter(cfg configuration, logger *slog.Logger, kinds []string, complete bool, export func(manifestWriter) error) error {
+	if !complete {
+		if cfg.Output.Prune {
+			logger.Warn("export is filtered. not pruning stale manifests")
+		}
+		kinds = nil
+	}
+	w, err := newManifestWriter(cfg, os.Stdout, logger, kinds...)
+	if err != nil {
+		return fmt.Errorf("output: %w", err)
+	}
+	if err = export(w); err != nil {
+		// the manifests written so far are still added to the kustomization, so they aren't silently left out
+		// when applying it. Nothing is pruned, as the export is incomplete.
+		if d, ok := w.(*dirWriter); ok {
+			if kErr := d.updateKustomization(set.New[string]()); kErr != nil {
+				err = errors.Join(err, fmt.Errorf("kustomization: %w", kErr))
+			}
+		}
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codeberg.org/clambin/go-common/set"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDirWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    outputConfiguration
+		existing  map[string]string
+		wantFiles map[string]string
+	}{
+		{
+			name:   "default layout",
+			output: outputConfiguration{},
+			wantFiles: map[string]string{
+				"grafanadashboard/folder-1/db-1.yaml": testManifestBody("GrafanaDashboard", "db-1"),
+				"grafanadashboard/db-2.yaml":          testManifestBody("GrafanaDashboard", "db-2"),
+				"grafanadatasource/ds-1.yaml":         testManifestBody("GrafanaDatasource", "ds-1"),
+			},
+		},
+		{
+			name:   "custom layout",
+			output: outputConfiguration{Layout: "{namespace}/{kind}-{name}.yaml"},
+			wantFiles: map[string]string{
+				"monitoring/grafanadashboard-db-1.yaml":  testManifestBody("GrafanaDashboard", "db-1"),
+				"monitoring/grafanadashboard-db-2.yaml":  testManifestBody("GrafanaDashboard", "db-2"),
+				"monitoring/grafanadatasource-ds-1.yaml": testManifestBody("GrafanaDatasource", "ds-1"),
+			},
+		},
+		{
+			name:   "overwrite",
+			output: outputConfiguration{Existing: existingOverwrite},
+			existing: map[string]string{
+				"grafanadashboard/db-2.yaml": testManifestBody("GrafanaDashboard", "old"),
+			},
+			wantFiles: map[string]string{
+				"grafanadashboard/folder-1/db-1.yaml": testManifestBody("GrafanaDashboard", "db-1"),
+				"grafanadashboard/db-2.yaml":          testManifestBody("GrafanaDashboard", "db-2"),
+				"grafanadatasource/ds-1.yaml":         testManifestBody("GrafanaDatasource", "ds-1"),
+			},
+		},
+		{
+			name:   "skip",
+			output: outputConfiguration{Existing: existingSkip},
+			existing: map[string]string{
+				"grafanadashboard/db-2.yaml": testManifestBody("GrafanaDashboard", "old"),
+			},
+			wantFiles: map[string]string{
+				"grafanadashboard/folder-1/db-1.yaml": testManifestBody("GrafanaDashboard", "db-1"),
+				"grafanadashboard/db-2.yaml":          testManifestBody("GrafanaDashboard", "old"),
+				"grafanadatasource/ds-1.yaml":         testManifestBody("GrafanaDatasource", "ds-1"),
+			},
+		},
+		{
+			name:   "prune",
+			output: outputConfiguration{Prune: true},
+			existing: map[string]string{
+				"grafanadashboard/folder-2/db-3.yaml": testManifestBody("GrafanaDashboard", "db-3"),
+				"grafanafolder/folder-1.yaml":         testManifestBody("GrafanaFolder", "folder-1"),
+			},
+			wantFiles: map[string]string{
+				"grafanadashboard/folder-1/db-1.yaml": testManifestBody("GrafanaDashboard", "db-1"),
+				"grafanadashboard/db-2.yaml":          testManifestBody("GrafanaDashboard", "db-2"),
+				"grafanadatasource/ds-1.yaml":         testManifestBody("GrafanaDatasource", "ds-1"),
+				"grafanafolder/folder-1.yaml":         testManifestBody("GrafanaFolder", "folder-1"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for path, body := range tt.existing {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, path)), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, path), []byte(body), 0644))
+			}
+
+			tt.output.Dir = tmpDir
+			cfg := configuration{Namespace: "monitoring", Output: tt.output}
+			w, err := newManifestWriter(cfg, nil, slog.New(slog.DiscardHandler), "GrafanaDashboard", "GrafanaDatasource")
+			require.NoError(t, err)
+
+			require.NoError(t, w.WriteManifest("GrafanaDashboard", "folder 1", "db-1", testManifest("GrafanaDashboard", "db-1")))
+			require.NoError(t, w.WriteManifest("GrafanaDashboard", "", "db-2", testManifest("GrafanaDashboard", "db-2")))
+			require.NoError(t, w.WriteManifest("GrafanaDatasource", "", "ds-1", testManifest("GrafanaDatasource", "ds-1")))
+			require.NoError(t, w.Close())
+
+			files := make(map[string]string)
+			require.NoError(t, filepath.WalkDir(tmpDir, func(path string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() || d.Name() == kustomizationFile {
+					return err
+				}
+				body, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(tmpDir, path)
+				files[rel] = string(body)
+				return nil
+			}))
+			assert.Equal(t, tt.wantFiles, files)
+
+			if tt.output.Prune {
+				assert.NoDirExists(t, filepath.Join(tmpDir, "grafanadashboard", "folder-2"))
+			}
+		})
+	}
+}
+
+func TestDirWriter_Kustomization(t *testing.T) {
+	tmpDir := t.TempDir()
+	existing := map[string]string{
+		kustomizationFile: `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+commonLabels:
+  app: grafana
+resources:
+- grafanafolder/folder-1.yaml
+- grafanadashboard/old.yaml
+`,
+		"grafanafolder/folder-1.yaml": testManifestBody("GrafanaFolder", "folder-1"),
+		"grafanadashboard/old.yaml":   testManifestBody("GrafanaDashboard", "old"),
+	}
+	for path, body := range existing {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, path), []byte(body), 0644))
+	}
+
+	cfg := configuration{
+		Grafana:   grafanaConfiguration{Operator: grafanaOperatorConfiguration{Labels: map[string]string{"dashboards": "grafana"}}},
+		Namespace: "monitoring",
+		Output:    outputConfiguration{Dir: tmpDir, Prune: true},
+	}
+	w, err := newManifestWriter(cfg, nil, slog.New(slog.DiscardHandler), "GrafanaDashboard")
+	require.NoError(t, err)
+	require.NoError(t, w.WriteManifest("GrafanaDashboard", "folder 1", "db-1", testManifest("GrafanaDashboard", "db-1")))
+	require.NoError(t, w.WriteManifest("GrafanaDashboard", "", "db-2", testManifest("GrafanaDashboard", "db-2")))
+	require.NoError(t, w.Close())
+
+	body, err := os.ReadFile(filepath.Join(tmpDir, kustomizationFile))
+	require.NoError(t, err)
+	assert.Equal(t, `apiVersion: kustomize.config.k8s.io/v1beta1
+commonLabels:
+  app: grafana
+  dashboards: grafana
+kind: Kustomization
+namespace: monitoring
+resources:
+- grafanadashboard/db-2.yaml
+- grafanadashboard/folder-1/db-1.yaml
+- grafanafolder/folder-1.yaml
+`, string(body))
+}
+
+func TestNewManifestWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  outputConfiguration
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{name: "stdout", output: outputConfiguration{}, wantErr: assert.NoError},
+		{name: "directory", output: outputConfiguration{Dir: "foo"}, wantErr: assert.NoError},
+		{name: "missing name in layout", output: outputConfiguration{Dir: "foo", Layout: "{kind}.yaml"}, wantErr: assert.Error},
+		{name: "invalid existing policy", output: outputConfiguration{Dir: "foo", Existing: "foo"}, wantErr: assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newManifestWriter(configuration{Output: tt.output}, nil, slog.New(slog.DiscardHandler))
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+type testManifestType struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+}
+
+func testManifest(kind, name string) testManifestType {
+	return testManifestType{
+		TypeMeta:   metav1.TypeMeta{Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+}
+
+// testManifestBody returns the YAML body written for testManifest(kind, name).
+func testManifestBody(kind, name string) string {
+	return "kind: " + kind + "\nmetadata:\n  name: " + name + "\n"
+}
+
+func TestWithManifestWriter_Prune(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+	v := viper.New()
+	v.Set("grafana.url", "http://grafana")
+	v.Set("output.dir", dir)
+	v.Set("output.prune", true)
+	client := func(uids ...string) *grafanaClient {
+		var hits models.HitList
+		dashboards := make(map[string]any)
+		for _, uid := range uids {
+			hits = append(hits, &models.Hit{Title: "db " + uid, Type: "dash-db", UID: uid})
+			dashboards[uid] = map[string]any{"title": "db " + uid}
+		}
+		return &grafanaClient{Search: fakeSearcher{hitList: hits}, Dashboards: fakeDashboardFetcher{dashboards: dashboards}}
+	}
+	exportDashboardsTo := func(cfg configuration, client *grafanaClient, args ...string) error {
+		complete := len(args) == 0 && cfg.DashboardFilter.empty()
+		return withManifestWriter(cfg, logger, dashboardKinds(cfg), complete, func(w manifestWriter) error {
+			return exportDashboards(w, client, cfg, set.New(args...), logger)
+		})
+	}
+
+	// a library panel written by the library-panels command
+	libraryPanel := filepath.Join(dir, "grafanalibrarypanel", "panel-1.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(libraryPanel), 0755))
+	require.NoError(t, os.WriteFile(libraryPanel, []byte(testManifestBody("GrafanaLibraryPanel", "panel-1")), 0644))
+
+	cfg := configurationFromViper(v)
+	require.NoError(t, exportDashboardsTo(cfg, client("1", "2")))
+	assert.FileExists(t, filepath.Join(dir, "grafanadashboard", "db-2.yaml"))
+
+	// filtered exports don't prune
+	require.NoError(t, exportDashboardsTo(cfg, client("1", "2"), "db 1"))
+	assert.FileExists(t, filepath.Join(dir, "grafanadashboard", "db-2.yaml"))
+	v.Set("dashboards.include.uid", []string{"1"})
+	require.NoError(t, exportDashboardsTo(configurationFromViper(v), client("1", "2")))
+	assert.FileExists(t, filepath.Join(dir, "grafanadashboard", "db-2.yaml"))
+	v.Set("dashboards.include.uid", nil)
+
+	// a complete export prunes stale dashboards, but leaves other kinds alone
+	require.NoError(t, exportDashboardsTo(cfg, client("1")))
+	assert.NoFileExists(t, filepath.Join(dir, "grafanadashboard", "db-2.yaml"))
+	assert.FileExists(t, filepath.Join(dir, "grafanadashboard", "db-1.yaml"))
+	assert.FileExists(t, libraryPanel)
+}
+
+func TestWithManifestWriter_Error(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "grafanadashboard", "stale.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(stale), 0755))
+	require.NoError(t, os.WriteFile(stale, []byte(testManifestBody("GrafanaDashboard", "stale")), 0644))
+	cfg := configuration{Output: outputConfiguration{Dir: dir, Prune: true}}
+
+	err := withManifestWriter(cfg, slog.New(slog.DiscardHandler), []string{"GrafanaDashboard"}, true, func(w manifestWriter) error {
+		if err := w.WriteManifest("GrafanaDashboard", "", "db-1", testManifest("GrafanaDashboard", "db-1")); err != nil {
+			return err
+		}
+		return errors.New("1 item(s) skipped")
+	})
+	require.Error(t, err)
+	assert.Equal(t, "1 item(s) skipped", err.Error())
+
+	// the written manifest is added to the kustomization, but stale manifests aren't pruned
+	body, err := os.ReadFile(filepath.Join(dir, kustomizationFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "- grafanadashboard/db-1.yaml")
+	assert.FileExists(t, stale)
+}
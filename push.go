@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"github.com/go-openapi/runtime"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	pushCmd = &cobra.Command{
+		Use:   "push [flags] path [...]",
+		Short: "apply grafana-operator custom resources to Grafana",
+		Long: `Applies GrafanaDashboard and GrafanaDatasource manifests to Grafana through its HTTP API, without the grafana-operator.
+If a path is a directory, all .yaml and .yml files in that directory (and its subdirectories) are applied.
+
+Datasources are applied before dashboards. The folders of the dashboards are created as needed. Dashboards may refer
+to their folder by title, UID or GrafanaFolder resource, and may store their JSON in a ConfigMap, as long as
+the GrafanaFolder and ConfigMap manifests are applied together with the dashboard.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := configurationFromViper(viper.GetViper())
+			logger := charmer.GetLogger(cmd)
+			client, err := cfg.grafanaClient()
+			if err != nil {
+				return fmt.Errorf("grafana: %w", err)
+			}
+			return pushManifests(cmd.OutOrStdout(), client, cfg, args, logger)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+	pushCmd.Flags().Bool("dry-run", false, "Show what would be applied, without changing Grafana")
+	_ = viper.BindPFlag("push.dryRun", pushCmd.Flags().Lookup("dry-run"))
+	pushCmd.Flags().String("existing", existingSkip, "What to do with dashboards & datasources that already exist in Grafana: overwrite or skip")
+	_ = viper.BindPFlag("push.existing", pushCmd.Flags().Lookup("existing"))
+}
+
+type pushConfiguration struct {
+	DryRun   bool
+	Existing string
+}
+
+// pushMessage is the commit message of the dashboard versions created by a push.
+const pushMessage = "pushed by grope"
+
+// pushManifests applies the manifests in paths to Grafana. It reports each applied object to w.
+func pushManifests(w io.Writer, client *grafanaClient, cfg configuration, paths []string, logger *slog.Logger) error {
+	switch cfg.Push.Existing {
+	case "":
+		cfg.Push.Existing = existingSkip
+	case existingOverwrite, existingSkip:
+	default:
+		return fmt.Errorf("invalid push existing policy %q", cfg.Push.Existing)
+	}
+	manifests, err := readPushManifests(paths)
+	if err != nil {
+		return err
+	}
+	p := pusher{
+		w:         w,
+		client:    client,
+		cfg:       cfg,
+		logger:    logger,
+		manifests: manifests,
+	}
+
+	var skipped skippedItems
+	for _, datasource := range manifests.datasources {
+		if err = p.pushDatasource(datasource); err != nil {
+			if err = skipped.skip(cfg, logger, fmt.Errorf("datasource %q: %w", datasource.Name, err)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, dashboard := range manifests.dashboards {
+		if err = p.pushDashboard(dashboard); err != nil {
+			if err = skipped.skip(cfg, logger, fmt.Errorf("dashboard %q: %w", dashboard.Name, err)); err != nil {
+				return err
+			}
+		}
+	}
+	return skipped.err()
+}
+
+// pushSet holds the manifests to apply. GrafanaFolders and ConfigMaps are keyed by their namespace and name,
+// so dashboards can refer to them.
+type pushSet struct {
+	dashboards  []dashboardManifest
+	datasources []datasourceManifest
+	folders     map[string]folderManifest
+	configMaps  map[string]corev1.ConfigMap
+}
+
+func readPushManifests(paths []string) (pushSet, error) {
+	manifests := pushSet{
+		folders:    make(map[string]folderManifest),
+		configMaps: make(map[string]corev1.ConfigMap),
+	}
+	for _, path := range paths {
+		err := readManifests(path, func(_ string, document []byte) error {
+			var typeMeta metav1.TypeMeta
+			if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+				return err
+			}
+			switch typeMeta.Kind {
+			case "GrafanaDashboard":
+				var dashboard dashboardManifest
+				if err := yaml.Unmarshal(document, &dashboard); err != nil {
+					return err
+				}
+				manifests.dashboards = append(manifests.dashboards, dashboard)
+			case "GrafanaDatasource":
+				var datasource datasourceManifest
+				if err := yaml.Unmarshal(document, &datasource); err != nil {
+					return err
+				}
+				manifests.datasources = append(manifests.datasources, datasource)
+			case "GrafanaFolder":
+				var folder folderManifest
+				if err := yaml.Unmarshal(document, &folder); err != nil {
+					return err
+				}
+				manifests.folders[objectKey(folder.Namespace, folder.Name)] = folder
+			case "ConfigMap":
+				var configMap corev1.ConfigMap
+				if err := yaml.Unmarshal(document, &configMap); err != nil {
+					return err
+				}
+				manifests.configMaps[objectKey(configMap.Namespace, configMap.Name)] = configMap
+			}
+			return nil
+		})
+		if err != nil {
+			return pushSet{}, err
+		}
+	}
+	return manifests, nil
+}
+
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// pusher applies manifests to Grafana.
+type pusher struct {
+	w         io.Writer
+	client    *grafanaClient
+	cfg       configuration
+	logger    *slog.Logger
+	manifests pushSet
+	// folders maps the parent UID & title of the folders in Grafana onto their UID. All folders are loaded
+	// when first looking up a folder by title.
+	folders       map[string]string
+	foldersLoaded bool
+	// folderUIDs holds the UIDs of the folders known to exist.
+	folderUIDs map[string]bool
+}
+
+// report writes the action taken for an object.
+func (p *pusher) report(kind, name, action string) {
+	if p.cfg.Push.DryRun {
+		action += " (dry run)"
+	}
+	_, _ = fmt.Fprintf(p.w, "%s/%s: %s\n", kind, name, action)
+}
+
+func (p *pusher) pushDatasource(manifest datasourceManifest) error {
+	ds := manifest.Spec.Datasource
+	if ds == nil {
+		return errors.New("no datasource in manifest")
+	}
+	uid := ds.UID
+	if manifest.Spec.CustomUID != "" {
+		uid = manifest.Spec.CustomUID
+	}
+	ref := datasourceRef{key: uid}
+	if uid == "" {
+		ref = datasourceRef{key: ds.Name, byName: true}
+	}
+	existing, err := grafanaDatasourceByRef(p.client, ref)
+	if err != nil {
+		if !isNotFound(err) {
+			return err
+		}
+		existing, err = nil, nil
+	}
+	if existing != nil && p.cfg.Push.Existing == existingSkip {
+		p.report(manifest.Kind, manifest.Name, "skipped (exists)")
+		return nil
+	}
+
+	var jsonData models.JSON
+	if len(ds.JSONData) > 0 {
+		if err = json.Unmarshal(ds.JSONData, &jsonData); err != nil {
+			return fmt.Errorf("jsonData: %w", err)
+		}
+	}
+	var secureJSONData map[string]string
+	if len(ds.SecureJSONData) > 0 {
+		if err = json.Unmarshal(ds.SecureJSONData, &secureJSONData); err != nil {
+			return fmt.Errorf("secureJsonData: %w", err)
+		}
+	}
+
+	if existing == nil {
+		if !p.cfg.Push.DryRun {
+			_, err = p.client.DatasourceWriter.AddDataSource(&models.AddDataSourceCommand{
+				UID:            uid,
+				Name:           ds.Name,
+				Type:           ds.Type,
+				URL:            ds.URL,
+				Access:         models.DsAccess(ds.Access),
+				Database:       ds.Database,
+				User:           ds.User,
+				IsDefault:      derefOrZero(ds.IsDefault),
+				BasicAuth:      derefOrZero(ds.BasicAuth),
+				BasicAuthUser:  ds.BasicAuthUser,
+				JSONData:       jsonData,
+				SecureJSONData: secureJSONData,
+			})
+		}
+		if err == nil {
+			p.report(manifest.Kind, manifest.Name, "created")
+		}
+		return err
+	}
+
+	if !p.cfg.Push.DryRun {
+		_, err = p.client.DatasourceWriter.UpdateDataSourceByUID(existing.UID, &models.UpdateDataSourceCommand{
+			UID:            existing.UID,
+			Name:           ds.Name,
+			Type:           ds.Type,
+			URL:            ds.URL,
+			Access:         models.DsAccess(ds.Access),
+			Database:       ds.Database,
+			User:           ds.User,
+			IsDefault:      derefOrZero(ds.IsDefault),
+			BasicAuth:      derefOrZero(ds.BasicAuth),
+			BasicAuthUser:  ds.BasicAuthUser,
+			JSONData:       jsonData,
+			SecureJSONData: secureJSONData,
+		})
+	}
+	if err == nil {
+		p.report(manifest.Kind, manifest.Name, "updated")
+	}
+	return err
+}
+
+func (p *pusher) pushDashboard(manifest dashboardManifest) error {
+	model, err := p.dashboardModel(manifest)
+	if err != nil {
+		return err
+	}
+	if manifest.Spec.CustomUID != "" {
+		model["uid"] = manifest.Spec.CustomUID
+	}
+	var exists bool
+	if uid, _ := model["uid"].(string); uid != "" {
+		_, err = p.client.Dashboards.GetDashboardByUID(uid)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		exists = err == nil
+	}
+	if exists && p.cfg.Push.Existing == existingSkip {
+		p.report(manifest.Kind, manifest.Name, "skipped (exists)")
+		return nil
+	}
+
+	folderUID, err := p.folderUID(manifest)
+	if err != nil {
+		return fmt.Errorf("folder: %w", err)
+	}
+	// the dashboard's ID is specific to the Grafana instance it was exported from.
+	delete(model, "id")
+	if !p.cfg.Push.DryRun {
+		if _, err = p.client.DashboardWriter.PostDashboard(&models.SaveDashboardCommand{
+			Dashboard: model,
+			FolderUID: folderUID,
+			Overwrite: exists,
+			Message:   pushMessage,
+		}); err != nil {
+			return err
+		}
+	}
+	if exists {
+		p.report(manifest.Kind, manifest.Name, "updated")
+	} else {
+		p.report(manifest.Kind, manifest.Name, "created")
+	}
+	return nil
+}
+
+// dashboardModel returns the dashboard's JSON model, with its datasource inputs replaced by the datasources' names,
+// as the grafana-operator would do.
+func (p *pusher) dashboardModel(manifest dashboardManifest) (map[string]any, error) {
+	var body []byte
+	switch {
+	case manifest.Spec.JSON != "":
+		body = []byte(manifest.Spec.JSON)
+	case len(manifest.Spec.GzipJSON) > 0:
+		var err error
+		if body, err = gunzipBytes(manifest.Spec.GzipJSON); err != nil {
+			return nil, fmt.Errorf("gzipJson: %w", err)
+		}
+	case manifest.Spec.ConfigMapRef != nil:
+		ref := manifest.Spec.ConfigMapRef
+		configMap, ok := p.manifests.configMaps[objectKey(manifest.Namespace, ref.Name)]
+		if !ok {
+			return nil, fmt.Errorf("config map %q not found", ref.Name)
+		}
+		body = []byte(configMap.Data[ref.Key])
+	default:
+		return nil, errors.New("no dashboard JSON in manifest")
+	}
+	for _, input := range manifest.Spec.Datasources {
+		body = bytes.ReplaceAll(body, []byte("${"+input.InputName+"}"), []byte(input.DatasourceName))
+	}
+	var model map[string]any
+	if err := json.Unmarshal(body, &model); err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+	return model, nil
+}
+
+// folderUID returns the UID of the dashboard's folder, creating the folder if it doesn't exist.
+// Dashboards without a folder are stored in the General folder, which has no UID.
+func (p *pusher) folderUID(manifest dashboardManifest) (string, error) {
+	switch {
+	case manifest.Spec.FolderUID != "":
+		// if the folder doesn't exist, use the title of its GrafanaFolder resource, if any.
+		title := manifest.Spec.FolderUID
+		for _, folder := range p.manifests.folders {
+			if folder.Spec.CustomUID == manifest.Spec.FolderUID && folder.Spec.Title != "" {
+				title = folder.Spec.Title
+			}
+		}
+		return p.ensureFolderUID(manifest.Spec.FolderUID, title, "")
+	case manifest.Spec.FolderRef != "":
+		folder, ok := p.manifests.folders[objectKey(manifest.Namespace, manifest.Spec.FolderRef)]
+		if !ok {
+			return "", fmt.Errorf("GrafanaFolder %q not found", manifest.Spec.FolderRef)
+		}
+		return p.ensureFolder(folder)
+	case manifest.Spec.FolderTitle != "":
+		return p.ensureFolderTitle(manifest.Spec.FolderTitle, "")
+	default:
+		return "", nil
+	}
+}
+
+// ensureFolder returns the UID of the folder of a GrafanaFolder resource (and its parent folders), creating the
+// folder if it doesn't exist.
+func (p *pusher) ensureFolder(folder folderManifest) (string, error) {
+	parentUID := folder.Spec.ParentFolderUID
+	if folder.Spec.ParentFolderRef != "" {
+		parent, ok := p.manifests.folders[objectKey(folder.Namespace, folder.Spec.ParentFolderRef)]
+		if !ok {
+			return "", fmt.Errorf("GrafanaFolder %q not found", folder.Spec.ParentFolderRef)
+		}
+		var err error
+		if parentUID, err = p.ensureFolder(parent); err != nil {
+			return "", err
+		}
+	}
+	title := folder.Spec.Title
+	if title == "" {
+		title = folder.Name
+	}
+	if folder.Spec.CustomUID != "" {
+		return p.ensureFolderUID(folder.Spec.CustomUID, title, parentUID)
+	}
+	return p.ensureFolderTitle(title, parentUID)
+}
+
+// ensureFolderUID creates the folder with the UID, if it doesn't exist.
+func (p *pusher) ensureFolderUID(uid, title, parentUID string) (string, error) {
+	if p.folderUIDs[uid] {
+		return uid, nil
+	}
+	if _, err := p.client.Folders.GetFolderByUID(uid); err == nil {
+		p.addFolder(uid, title, parentUID)
+		return uid, nil
+	} else if !isNotFound(err) {
+		return "", err
+	}
+	return p.createFolder(uid, title, parentUID)
+}
+
+// ensureFolderTitle returns the UID of the folder with the title, creating the folder if it doesn't exist.
+func (p *pusher) ensureFolderTitle(title, parentUID string) (string, error) {
+	if !p.foldersLoaded {
+		p.foldersLoaded = true
+		_, err := walkFolders(p.client, nil, func(folder, parent *models.FolderSearchHit) bool {
+			var parentUID string
+			if parent != nil {
+				parentUID = parent.UID
+			}
+			p.addFolder(folder.UID, folder.Title, parentUID)
+			return true
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if uid, ok := p.folders[objectKey(parentUID, title)]; ok {
+		return uid, nil
+	}
+	return p.createFolder("", title, parentUID)
+}
+
+// createFolder creates a folder. If uid is blank, Grafana assigns a UID.
+func (p *pusher) createFolder(uid, title, parentUID string) (string, error) {
+	if !p.cfg.Push.DryRun {
+		created, err := p.client.FolderWriter.CreateFolder(&models.CreateFolderCommand{UID: uid, Title: title, ParentUID: parentUID})
+		if err != nil {
+			return "", err
+		}
+		uid = created.GetPayload().UID
+	} else if uid == "" {
+		// the folder doesn't exist, so dashboards can't refer to it anyway.
+		uid = title
+	}
+	p.addFolder(uid, title, parentUID)
+	p.report("GrafanaFolder", title, "created")
+	return uid, nil
+}
+
+func (p *pusher) addFolder(uid, title, parentUID string) {
+	if p.folders == nil {
+		p.folders = make(map[string]string)
+		p.folderUIDs = make(map[string]bool)
+	}
+	p.folders[objectKey(parentUID, title)] = uid
+	p.folderUIDs[uid] = true
+}
+
+// isNotFound returns true if err is a 404 response from the Grafana API.
+func isNotFound(err error) bool {
+	var status runtime.ClientResponseStatus
+	return errors.As(err, &status) && status.IsCode(http.StatusNotFound)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGrafana is an in-memory Grafana, serving the parts of the HTTP API used by push.
+type fakeGrafana struct {
+	lock        sync.Mutex
+	datasources map[string]models.DataSource
+	folders     map[string]models.Folder
+	dashboards  map[string]models.SaveDashboardCommand
+	writes      int
+}
+
+func newFakeGrafana(t *testing.T) (*fakeGrafana, *httptest.Server) {
+	t.Helper()
+	f := fakeGrafana{
+		datasources: make(map[string]models.DataSource),
+		folders:     make(map[string]models.Folder),
+		dashboards:  make(map[string]models.SaveDashboardCommand),
+	}
+	m := http.NewServeMux()
+	m.HandleFunc("GET /api/datasources/uid/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		ds, ok := f.datasources[r.PathValue("uid")]
+		f.respond(w, ok, ds)
+	})
+	m.HandleFunc("GET /api/datasources/name/{name}", func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		for _, ds := range f.datasources {
+			if ds.Name == r.PathValue("name") {
+				f.respond(w, true, ds)
+				return
+			}
+		}
+		f.respond(w, false, nil)
+	})
+	m.HandleFunc("POST /api/datasources", func(w http.ResponseWriter, r *http.Request) {
+		var cmd models.AddDataSourceCommand
+		f.decode(w, r, &cmd, func() any {
+			ds := models.DataSource{UID: cmd.UID, Name: cmd.Name, Type: cmd.Type, URL: cmd.URL, JSONData: cmd.JSONData}
+			f.datasources[cmd.UID] = ds
+			return models.AddDataSourceOKBody{Datasource: &ds, Message: new("Datasource added"), Name: &cmd.Name}
+		})
+	})
+	m.HandleFunc("PUT /api/datasources/uid/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		var cmd models.UpdateDataSourceCommand
+		f.decode(w, r, &cmd, func() any {
+			ds := models.DataSource{UID: r.PathValue("uid"), Name: cmd.Name, Type: cmd.Type, URL: cmd.URL, JSONData: cmd.JSONData}
+			f.datasources[ds.UID] = ds
+			return models.UpdateDataSourceByUIDOKBody{Datasource: &ds, Message: new("Datasource updated"), Name: &cmd.Name}
+		})
+	})
+	m.HandleFunc("GET /api/folders", func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		hits := []models.FolderSearchHit{}
+		if r.URL.Query().Get("page") == "1" {
+			for _, folder := range f.folders {
+				if folder.ParentUID == r.URL.Query().Get("parentUid") {
+					hits = append(hits, models.FolderSearchHit{UID: folder.UID, Title: folder.Title, ParentUID: folder.ParentUID})
+				}
+			}
+		}
+		f.respond(w, true, hits)
+	})
+	m.HandleFunc("GET /api/folders/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		folder, ok := f.folders[r.PathValue("uid")]
+		f.respond(w, ok, folder)
+	})
+	m.HandleFunc("POST /api/folders", func(w http.ResponseWriter, r *http.Request) {
+		var cmd models.CreateFolderCommand
+		f.decode(w, r, &cmd, func() any {
+			if cmd.UID == "" {
+				cmd.UID = "folder-" + strconv.Itoa(len(f.folders)+1)
+			}
+			folder := models.Folder{UID: cmd.UID, Title: cmd.Title, ParentUID: cmd.ParentUID}
+			f.folders[cmd.UID] = folder
+			return folder
+		})
+	})
+	m.HandleFunc("GET /api/dashboards/uid/{uid}", func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		db, ok := f.dashboards[r.PathValue("uid")]
+		f.respond(w, ok, models.DashboardFullWithMeta{Dashboard: db.Dashboard, Meta: &models.DashboardMeta{FolderUID: db.FolderUID}})
+	})
+	m.HandleFunc("POST /api/dashboards/db", func(w http.ResponseWriter, r *http.Request) {
+		var cmd models.SaveDashboardCommand
+		f.decode(w, r, &cmd, func() any {
+			uid, _ := cmd.Dashboard.(map[string]any)["uid"].(string)
+			f.dashboards[uid] = cmd
+			return models.PostDashboardOKBody{UID: &uid, Status: new("success"), URL: new("/d/" + uid), Version: new(int64(1)), ID: new(int64(1))}
+		})
+	})
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+	return &f, s
+}
+
+func (f *fakeGrafana) respond(w http.ResponseWriter, ok bool, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		body = map[string]string{"message": "not found"}
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// decode decodes a write request and stores it through apply, which returns the response.
+func (f *fakeGrafana) decode(w http.ResponseWriter, r *http.Request, cmd any, apply func() any) {
+	if err := json.NewDecoder(r.Body).Decode(cmd); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.writes++
+	f.respond(w, true, apply())
+}
+
+func TestPushManifests(t *testing.T) {
+	f, s := newFakeGrafana(t)
+	v := viper.New()
+	v.Set("grafana.url", s.URL)
+	v.Set("grafana.username", "admin")
+	v.Set("grafana.password", "admin")
+
+	tests := []struct {
+		name     string
+		dryRun   bool
+		existing string
+		want     string
+		writes   int
+	}{
+		{
+			name:   "dry run",
+			dryRun: true,
+			want: `GrafanaDatasource/prometheus: created (dry run)
+GrafanaFolder/folder 1: created (dry run)
+GrafanaDashboard/db-1: created (dry run)
+GrafanaFolder/folder 2: created (dry run)
+GrafanaDashboard/db-2: created (dry run)
+GrafanaDashboard/db-3: created (dry run)
+`,
+		},
+		{
+			name: "create",
+			want: `GrafanaDatasource/prometheus: created
+GrafanaFolder/folder 1: created
+GrafanaDashboard/db-1: created
+GrafanaFolder/folder 2: created
+GrafanaDashboard/db-2: created
+GrafanaDashboard/db-3: created
+`,
+			writes: 6,
+		},
+		{
+			name: "skip existing",
+			want: `GrafanaDatasource/prometheus: skipped (exists)
+GrafanaDashboard/db-1: skipped (exists)
+GrafanaDashboard/db-2: skipped (exists)
+GrafanaDashboard/db-3: skipped (exists)
+`,
+		},
+		{
+			name:     "overwrite existing",
+			existing: existingOverwrite,
+			want: `GrafanaDatasource/prometheus: updated
+GrafanaDashboard/db-1: updated
+GrafanaDashboard/db-2: updated
+GrafanaDashboard/db-3: updated
+`,
+			writes: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.Set("push.dryRun", tt.dryRun)
+			v.Set("push.existing", tt.existing)
+			cfg := configurationFromViper(v)
+			client, err := cfg.grafanaClient()
+			require.NoError(t, err)
+
+			f.writes = 0
+			var buf bytes.Buffer
+			require.NoError(t, pushManifests(&buf, client, cfg, []string{"testdata/push"}, slog.New(slog.DiscardHandler)))
+			assert.Equal(t, tt.want, buf.String())
+			assert.Equal(t, tt.writes, f.writes)
+		})
+	}
+
+	// the pushed objects are stored as the grafana-operator would
+	require.Contains(t, f.datasources, "prom-1")
+	assert.Equal(t, "http://prometheus", f.datasources["prom-1"].URL)
+	require.Len(t, f.folders, 2)
+	assert.Equal(t, "folder 2", f.folders["f2"].Title)
+	require.Len(t, f.dashboards, 3)
+	db1 := f.dashboards["db-1"]
+	assert.Equal(t, "folder 1", f.folders[db1.FolderUID].Title)
+	assert.True(t, db1.Overwrite)
+	assert.Equal(t, pushMessage, db1.Message)
+	model := db1.Dashboard.(map[string]any)
+	assert.NotContains(t, model, "id")
+	assert.Equal(t, "prometheus", model["panels"].([]any)[0].(map[string]any)["datasource"].(map[string]any)["uid"])
+	assert.Equal(t, "f2", f.dashboards["db-2"].FolderUID)
+	assert.Empty(t, f.dashboards["db-3"].FolderUID)
+	assert.Equal(t, "db 3", f.dashboards["db-3"].Dashboard.(map[string]any)["title"])
+}
+
+func TestPushManifests_Errors(t *testing.T) {
+	_, s := newFakeGrafana(t)
+	v := viper.New()
+	v.Set("grafana.url", s.URL)
+	v.Set("grafana.username", "admin")
+	v.Set("grafana.password", "admin")
+
+	tests := []struct {
+		name     string
+		existing string
+		manifest string
+		wantErr  string
+	}{
+		{
+			name:     "invalid policy",
+			existing: "replace",
+			wantErr:  `invalid push existing policy "replace"`,
+		},
+		{
+			name: "missing folder",
+			manifest: `apiVersion: grafana.integreatly.org/v1beta1
+kind: GrafanaDashboard
+metadata:
+  name: db-1
+spec:
+  folderRef: missing
+  json: '{"uid":"db-1","title":"db 1"}'
+`,
+			wantErr: `dashboard "db-1": folder: GrafanaFolder "missing" not found`,
+		},
+		{
+			name: "missing config map",
+			manifest: `apiVersion: grafana.integreatly.org/v1beta1
+kind: GrafanaDashboard
+metadata:
+  name: db-1
+spec:
+  configMapRef:
+    name: missing
+    key: dashboard.json
+`,
+			wantErr: `dashboard "db-1": config map "missing" not found`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.Set("push.existing", tt.existing)
+			cfg := configurationFromViper(v)
+			client, err := cfg.grafanaClient()
+			require.NoError(t, err)
+			path := t.TempDir() + "/manifest.yaml"
+			require.NoError(t, os.WriteFile(path, []byte(tt.manifest), 0644))
+
+			err = pushManifests(&bytes.Buffer{}, client, cfg, []string{path}, slog.New(slog.DiscardHandler))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
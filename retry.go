@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/grafana/grafana-openapi-client-go/client/dashboards"
+	"github.com/grafana/grafana-openapi-client-go/client/datasources"
+	"github.com/grafana/grafana-openapi-client-go/client/search"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Int("retries", defaultRetries, "Number of times to retry a Grafana API call that failed with a transient error")
+	_ = viper.BindPFlag("retry.max", rootCmd.PersistentFlags().Lookup("retries"))
+	rootCmd.PersistentFlags().Duration("retry-backoff", defaultRetryBackoff, "Time to wait before the first retry. Doubles with each retry")
+	_ = viper.BindPFlag("retry.backoff", rootCmd.PersistentFlags().Lookup("retry-backoff"))
+	rootCmd.PersistentFlags().Float64("rate-limit", 0, "Maximum number of Grafana API calls per second (default: no limit)")
+	_ = viper.BindPFlag("rateLimit", rootCmd.PersistentFlags().Lookup("rate-limit"))
+}
+
+const (
+	defaultRetries         = 3
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+)
+
+type retryConfiguration struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	RateLimit  float64
+}
+
+// retrier retries Grafana API calls that fail with a transient error, with exponential backoff.
+// It also limits the rate at which API calls are made.
+type retrier struct {
+	cfg     retryConfiguration
+	limiter *rate.Limiter
+	sleep   func(time.Duration)
+}
+
+func newRetrier(cfg retryConfiguration) *retrier {
+	limit := rate.Inf
+	if cfg.RateLimit > 0 {
+		limit = rate.Limit(cfg.RateLimit)
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return &retrier{
+		cfg:     cfg,
+		limiter: rate.NewLimiter(limit, 1),
+		sleep:   time.Sleep,
+	}
+}
+
+// call calls f, retrying it if it fails with a transient error.
+func call[T any](r *retrier, f func() (T, error)) (T, error) {
+	backoff := r.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		_ = r.limiter.Wait(context.Background())
+		result, err := f()
+		if err == nil || attempt >= r.cfg.MaxRetries || !isTransient(err) {
+			return result, err
+		}
+		wait := backoff
+		if retryAfter, ok := retryAfter(err); ok {
+			// don't let the server block the export for longer than we would wait ourselves.
+			wait = min(retryAfter, r.cfg.MaxBackoff)
+		}
+		r.sleep(wait)
+		backoff = min(2*backoff, r.cfg.MaxBackoff)
+	}
+}
+
+// isTransient returns true if err is worth retrying: the server is overloaded (429), temporarily unavailable (5xx),
+// or can't be reached (e.g. because it's restarting).
+func isTransient(err error) bool {
+	var status runtime.ClientResponseStatus
+	if errors.As(err, &status) {
+		return status.IsCode(http.StatusTooManyRequests) || status.IsServerError()
+	}
+	// connection errors & timeouts. Other errors (e.g. an invalid certificate) won't go away by retrying.
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// retryAfter returns the delay requested by the server's Retry-After header, if any.
+// The header is only available for status codes that the Grafana API doesn't document (e.g. 429 or 502).
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *runtime.APIError
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	response, ok := apiErr.Response.(runtime.ClientResponse)
+	if !ok {
+		return 0, false
+	}
+	header := response.GetHeader("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+var _ grafanaSearchClient = retryingSearchClient{}
+
+type retryingSearchClient struct {
+	grafanaSearchClient
+	retrier *retrier
+}
+
+func (c retryingSearchClient) Search(params *search.SearchParams, opts ...search.ClientOption) (*search.SearchOK, error) {
+	return call(c.retrier, func() (*search.SearchOK, error) {
+		return c.grafanaSearchClient.Search(params, opts...)
+	})
+}
+
+var _ grafanaDashboardClient = retryingDashboardClient{}
+
+type retryingDashboardClient struct {
+	grafanaDashboardClient
+	retrier *retrier
+}
+
+func (c retryingDashboardClient) GetDashboardByUID(uid string, opts ...dashboards.ClientOption) (*dashboards.GetDashboardByUIDOK, error) {
+	return call(c.retrier, func() (*dashboards.GetDashboardByUIDOK, error) {
+		return c.grafanaDashboardClient.GetDashboardByUID(uid, opts...)
+	})
+}
+
+var _ grafanaDatasourcesClient = retryingDatasourcesClient{}
+
+type retryingDatasourcesClient struct {
+	grafanaDatasourcesClient
+	retrier *retrier
+}
+
+func (c retryingDatasourcesClient) GetDataSourceByName(name string, opts ...datasources.ClientOption) (*datasources.GetDataSourceByNameOK, error) {
+	return call(c.retrier, func() (*datasources.GetDataSourceByNameOK, error) {
+		return c.grafanaDatasourcesClient.GetDataSourceByName(name, opts...)
+	})
+}
+
+func (c retryingDatasourcesClient) GetDataSourceByUID(uid string, opts ...datasources.ClientOption) (*datasources.GetDataSourceByUIDOK, error) {
+	return call(c.retrier, func() (*datasources.GetDataSourceByUIDOK, error) {
+		return c.grafanaDatasourcesClient.GetDataSourceByUID(uid, opts...)
+	})
+}
+
+func (c retryingDatasourcesClient) GetDataSources(opts ...datasources.ClientOption) (*datasources.GetDataSourcesOK, error) {
+	return call(c.retrier, func() (*datasources.GetDataSourcesOK, error) {
+		return c.grafanaDatasourcesClient.GetDataSources(opts...)
+	})
+}
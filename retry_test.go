@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"github.com/grafana/grafana-openapi-client-go/client/dashboards"
+	"github.com/grafana/grafana-openapi-client-go/client/search"
+	"github.com/grafana/grafana-openapi-client-go/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrier(t *testing.T) {
+	tests := []struct {
+		name       string
+		errs       []error
+		maxRetries int
+		wantErr    assert.ErrorAssertionFunc
+		wantCalls  int
+		wantSleeps []time.Duration
+	}{
+		{
+			name:      "success",
+			wantErr:   assert.NoError,
+			wantCalls: 1,
+		},
+		{
+			name:       "bad gateway",
+			errs:       []error{testAPIError(http.StatusBadGateway, ""), testAPIError(http.StatusBadGateway, "")},
+			maxRetries: 3,
+			wantErr:    assert.NoError,
+			wantCalls:  3,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "retry after",
+			errs:       []error{testAPIError(http.StatusTooManyRequests, "5")},
+			maxRetries: 3,
+			wantErr:    assert.NoError,
+			wantCalls:  2,
+			wantSleeps: []time.Duration{5 * time.Second},
+		},
+		{
+			name:       "typed server error",
+			errs:       []error{dashboards.NewGetDashboardByUIDInternalServerError()},
+			maxRetries: 3,
+			wantErr:    assert.NoError,
+			wantCalls:  2,
+			wantSleeps: []time.Duration{time.Second},
+		},
+		{
+			name:       "connection refused",
+			errs:       []error{&net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			maxRetries: 3,
+			wantErr:    assert.NoError,
+			wantCalls:  2,
+			wantSleeps: []time.Duration{time.Second},
+		},
+		{
+			name:       "too many failures",
+			errs:       []error{testAPIError(http.StatusBadGateway, ""), testAPIError(http.StatusBadGateway, ""), testAPIError(http.StatusBadGateway, "")},
+			maxRetries: 2,
+			wantErr:    assert.Error,
+			wantCalls:  3,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "not found",
+			errs:       []error{dashboards.NewGetDashboardByUIDNotFound()},
+			maxRetries: 3,
+			wantErr:    assert.Error,
+			wantCalls:  1,
+		},
+		{
+			name:       "other error",
+			errs:       []error{errors.New("invalid certificate")},
+			maxRetries: 3,
+			wantErr:    assert.Error,
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRetrier(retryConfiguration{MaxRetries: tt.maxRetries, Backoff: time.Second})
+			var sleeps []time.Duration
+			r.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+			fake := flakyDashboardFetcher{
+				errs:                 tt.errs,
+				fakeDashboardFetcher: fakeDashboardFetcher{dashboards: map[string]any{"1": map[string]any{}}},
+			}
+			client := retryingDashboardClient{grafanaDashboardClient: &fake, retrier: r}
+			_, err := client.GetDashboardByUID("1")
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantCalls, fake.calls)
+			assert.Equal(t, tt.wantSleeps, sleeps)
+		})
+	}
+}
+
+func TestRetrier_Backoff(t *testing.T) {
+	r := newRetrier(retryConfiguration{MaxRetries: 5, Backoff: 10 * time.Second, MaxBackoff: 30 * time.Second})
+	var sleeps []time.Duration
+	r.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	_, err := call(r, func() (any, error) { return nil, testAPIError(http.StatusServiceUnavailable, "") })
+	require.Error(t, err)
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second}, sleeps)
+
+	// Retry-After is capped by the maximum backoff
+	sleeps = nil
+	_, err = call(r, func() (any, error) { return nil, testAPIError(http.StatusTooManyRequests, "3600") })
+	require.Error(t, err)
+	assert.Equal(t, []time.Duration{30 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second}, sleeps)
+}
+
+func TestRetrier_RateLimit(t *testing.T) {
+	r := newRetrier(retryConfiguration{RateLimit: 50})
+	client := retryingSearchClient{grafanaSearchClient: fakeSearcher{hitList: models.HitList{{Title: "db 1"}}}, retrier: r}
+	params := search.NewSearchParams()
+	params.Page = constP(int64(1))
+	start := time.Now()
+	for range 6 {
+		_, err := client.Search(params)
+		require.NoError(t, err)
+	}
+	// the first call is immediate. the next 5 are spaced 20ms apart.
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+// flakyDashboardFetcher fails with the next error in errs, until all errors have been returned.
+type flakyDashboardFetcher struct {
+	fakeDashboardFetcher
+	errs  []error
+	calls int
+}
+
+func (f *flakyDashboardFetcher) GetDashboardByUID(uid string, opts ...dashboards.ClientOption) (*dashboards.GetDashboardByUIDOK, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return f.fakeDashboardFetcher.GetDashboardByUID(uid, opts...)
+}
+
+func TestGrafanaClient_Retry(t *testing.T) {
+	var calls atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"title":"db 1","type":"dash-db"}]`))
+	}))
+	t.Cleanup(s.Close)
+
+	v := viper.New()
+	v.Set("grafana.url", s.URL)
+	v.Set("grafana.token", "token")
+	v.Set("retry.max", 1)
+	v.Set("retry.backoff", time.Millisecond)
+	client, err := configurationFromViper(v).grafanaClient()
+	require.NoError(t, err)
+
+	hits, err := client.Search.Search(search.NewSearchParams())
+	require.NoError(t, err)
+	assert.Len(t, hits.GetPayload(), 1)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func testAPIError(code int, retryAfter string) error {
+	return runtime.NewAPIError("test", testClientResponse{code: code, headers: http.Header{"Retry-After": []string{retryAfter}}}, code)
+}
+
+var _ runtime.ClientResponse = testClientResponse{}
+
+type testClientResponse struct {
+	code    int
+	headers http.Header
+}
+
+func (r testClientResponse) Code() int                    { return r.code }
+func (r testClientResponse) Message() string              { return http.StatusText(r.code) }
+func (r testClientResponse) GetHeader(name string) string { return r.headers.Get(name) }
+func (r testClientResponse) GetHeaders(name string) []string {
+	return r.headers.Values(name)
+}
+func (r testClientResponse) Body() io.ReadCloser { return http.NoBody }
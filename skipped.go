@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Bool("continue-on-error", false, "Skip items that can't be retrieved from Grafana, rather than stopping the export. grope still exits with a non-zero status")
+	_ = viper.BindPFlag("continue-on-error", rootCmd.PersistentFlags().Lookup("continue-on-error"))
+}
+
+// skippedItems records the items that were skipped during an export because they couldn't be retrieved from Grafana.
+type skippedItems struct {
+	errs []error
+}
+
+// skip records err, if the configuration allows the export to continue on errors.
+// Otherwise, it returns err, so the export stops.
+func (s *skippedItems) skip(cfg configuration, logger *slog.Logger, err error) error {
+	if !cfg.ContinueOnError {
+		return err
+	}
+	logger.Warn("skipping item", "err", err)
+	s.errs = append(s.errs, err)
+	return nil
+}
+
+// err returns a summary of the skipped items, or nil if no items were skipped.
+// This ensures grope exits with a non-zero status if the export is incomplete.
+func (s *skippedItems) err() error {
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d item(s) skipped:\n%w", len(s.errs), errors.Join(s.errs...))
+}